@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ro-tex/skydb"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// backupTweak returns the tweak of the backup of the list with the given index
+// in cfg.Tweaks. It returns false if we don't back up the lists.
+func backupTweak(cfg config, i int) ([32]byte, bool) {
+	if i >= len(cfg.BackupTweaks) {
+		return [32]byte{}, false
+	}
+	return cfg.BackupTweaks[i], true
+}
+
+// writeBackup writes the given list under the given backup tweak. The backup
+// is best-effort, so we make a single attempt and only log failures. We don't
+// coordinate with other servers writing the backup, so the last writer wins,
+// which is fine since each of them writes a list it just announced to.
+func writeBackup(ctx context.Context, db skyDB, cfg config, tweak [32]byte, list []server) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+	defer cancel()
+	l := logger.With("backup", tweakID(tweak))
+	_, rev, err := readRawList(ctx, db, tweak, cfg.OwnName)
+	if err != nil && !errors.Contains(err, skydb.ErrNotFound) {
+		l.Warn("failed to read backup list", "error", err)
+		return
+	}
+	writeCtx, writeCancel := writeContext(ctx)
+	defer writeCancel()
+	err = putServerList(writeCtx, db, list, tweak, rev+1, cfg)
+	if err != nil {
+		l.Warn("failed to write backup list", "servers", len(list), "error", err)
+		return
+	}
+	l.Info("wrote backup list", "servers", len(list), "revision", rev+1)
+}
+
+// readListOrBackup reads the list with the given index in cfg.Tweaks. If
+// fallback is set and the read fails, it reads the backup of the list instead.
+func readListOrBackup(ctx context.Context, db skyDB, cfg config, i int, fallback bool) ([]server, error) {
+	list, _, err := getServerList(ctx, db, cfg.Tweaks[i], cfg.OwnName, cfg.TrustedKeys)
+	if err == nil {
+		return list, nil
+	}
+	backup, ok := backupTweak(cfg, i)
+	if !fallback || !ok {
+		return nil, err
+	}
+	logger.Warn("failed to get server list, reading its backup instead", "error", err)
+	list, _, backupErr := getServerList(ctx, db, backup, cfg.OwnName, cfg.TrustedKeys)
+	if backupErr != nil {
+		return nil, errors.Compose(err, errors.AddContext(backupErr, "failed to get backup list"))
+	}
+	return list, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestBackupList verifies that we mirror the list under the backup tweak once
+// the announce succeeded, that we don't without a backup tweak or after a
+// failed announce, that a failed backup doesn't fail the announce and that
+// readers can fall back to the backup.
+func TestBackupList(t *testing.T) {
+	backup := [32]byte{5, 6, 7, 8}
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	announceAllTo := func(cfg config, db *fakeDB) []error {
+		t.Helper()
+		failures, err := announceAll(context.Background(), db, newFakeSkyd(), cfg, pk, staticIP("1.1.1.1"), newMetrics(), nil, newStatus(cfg.StaleAfter), nil, outputText, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return failures
+	}
+
+	// Without a backup tweak.
+	db := newFakeDB()
+	announceAllTo(cfg, db)
+	if len(db.entries) != 1 || db.writeCount() != 1 {
+		t.Fatalf("expected a single write, got %d writes to %d lists", db.writeCount(), len(db.entries))
+	}
+
+	t.Setenv("SERVERLIST_BACKUP_TWEAK", hex.EncodeToString(backup[:]))
+	cfg = testConfig(t)
+	if len(cfg.BackupTweaks) != 1 || cfg.BackupTweaks[0] != backup {
+		t.Fatalf("unexpected backup tweaks %v", cfg.BackupTweaks)
+	}
+	db = newFakeDB()
+	announceAllTo(cfg, db)
+	if db.writeCount() != 2 {
+		t.Fatalf("expected 2 writes, got %d", db.writeCount())
+	}
+	primary, _ := db.storedList(t, testTweak)
+	mirrored, _ := db.storedList(t, backup)
+	if len(mirrored) != len(primary) || !sameServer(mirrored[0], primary[0]) {
+		t.Fatalf("expected the backup %v to match the list %v", mirrored, primary)
+	}
+
+	// A failed backup doesn't fail the announce.
+	db = newFakeDB()
+	db.onWrite = func(n int) error {
+		if n == 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if failures := announceAllTo(cfg, db); len(failures) != 0 {
+		t.Fatalf("expected the announce to succeed, got %v", failures)
+	}
+
+	// A failed announce isn't backed up.
+	db = newFakeDB()
+	cfg.MaxServers = 1
+	db.storeList(t, testTweak, primary)
+	db.storeList(t, testTweak, append(primary, server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: clock()}))
+	if failures := announceAllTo(cfg, db); len(failures) != 1 {
+		t.Fatalf("expected the announce to fail, got %v", failures)
+	}
+	if _, exists := db.entries[crypto.Hash(backup)]; exists {
+		t.Fatal("expected no backup of a failed announce")
+	}
+
+	// Readers fall back to the backup if asked to.
+	db = newFakeDB()
+	db.storeList(t, backup, primary)
+	db.onRead = func(n int) error {
+		if n == 1 || n == 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if _, err := readListOrBackup(context.Background(), db, cfg, 0, false); err == nil {
+		t.Fatal("expected the read to fail without the fallback")
+	}
+	list, err := readListOrBackup(context.Background(), db, cfg, 0, true)
+	if err != nil || len(list) != len(primary) {
+		t.Fatalf("expected the backup list, got %v and %v", list, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+type (
+	// checkResult is the outcome of a single self-test check. Err is nil if
+	// the check passed.
+	checkResult struct {
+		Name   string
+		Detail string
+		Err    error
+	}
+)
+
+// selfCheck verifies that we can talk to skyd with our API password and that
+// we can read each of our lists. It doesn't modify anything.
+func selfCheck(ctx context.Context, db skyDB, skyd skydClient, cfg config, pk crypto.PublicKey) []checkResult {
+	var results []checkResult
+
+	version, err := skydVersion(ctx, skyd)
+	results = append(results, checkResult{
+		Name:   "skyd is reachable at " + cfg.SkydAddress,
+		Detail: "version " + version,
+		Err:    err,
+	})
+
+	results = append(results, checkResult{
+		Name: "skyd accepts the api password",
+		Err:  checkAPIPassword(ctx, skyd),
+	})
+
+	for _, tweak := range cfg.Tweaks {
+		sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+		list, rev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+		results = append(results, checkResult{
+			Name:   "list " + sl.String() + " is readable",
+			Detail: fmt.Sprintf("revision %d, %d servers", rev, len(list)),
+			Err:    err,
+		})
+	}
+	return results
+}
+
+// checkAPIPassword makes a read-only request to skyd which requires the API
+// password. Neither SkyDB reads nor the daemon endpoints do, so we list the
+// renter's backups.
+func checkAPIPassword(ctx context.Context, skyd skydClient) error {
+	var err error
+	ctxErr := withContext(ctx, func() {
+		_, err = skyd.RenterBackups()
+	})
+	if ctxErr != nil {
+		return errors.AddContext(ctxErr, "failed to query skyd")
+	}
+	if isAuthError(err) {
+		return errors.Extend(err, ErrAuthFailed)
+	}
+	if err != nil {
+		return errors.AddContext(err, "failed to query skyd")
+	}
+	return nil
+}
+
+// printCheckResults prints a report of the given self-test results and returns
+// the number of failed checks.
+func printCheckResults(results []checkResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", r.Name, r.Err)
+			continue
+		}
+		if r.Detail != "" {
+			fmt.Printf("ok    %s (%s)\n", r.Name, r.Detail)
+		} else {
+			fmt.Printf("ok    %s\n", r.Name)
+		}
+	}
+	return failed
+}
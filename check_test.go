@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+)
+
+// wrongPasswordSkyd is a fakeSkyd which rejects the API password. Like skyd,
+// it still answers the calls which don't require one.
+type wrongPasswordSkyd struct {
+	*fakeSkyd
+}
+
+// RenterBackups implements skydClient.
+func (wrongPasswordSkyd) RenterBackups() (api.RenterBackupsGET, error) {
+	return api.RenterBackupsGET{}, errors.New("[" + skydAuthError + "]")
+}
+
+// TestSelfCheck verifies that the self-test passes against a healthy skyd,
+// reports a wrong API password and never writes the list.
+func TestSelfCheck(t *testing.T) {
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}})
+
+	results := selfCheck(context.Background(), db, newFakeSkyd(), cfg, pk)
+	if len(results) != 2+len(cfg.Tweaks) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if failed := printCheckResults(results); failed != 0 {
+		t.Fatalf("expected all checks to pass, got %v", results)
+	}
+
+	results = selfCheck(context.Background(), db, wrongPasswordSkyd{newFakeSkyd()}, cfg, pk)
+	if failed := printCheckResults(results); failed != 1 {
+		t.Fatalf("expected one failed check, got %v", results)
+	}
+	if !errors.Contains(results[1].Err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", results[1].Err)
+	}
+
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes, got %d", db.writeCount())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// compressedListVersion is the version of the compressed list format. It
+	// follows compressedListMagic.
+	compressedListVersion = 1
+	// maxDecompressedListSize is the maximum size of a decompressed list. It
+	// protects us from lists which decompress into absurd amounts of data.
+	maxDecompressedListSize = 16 << 20
+)
+
+var (
+	// compressedListMagic marks a compressed list. A JSON list can't start
+	// with a zero byte, so the marker never matches an uncompressed list.
+	compressedListMagic = []byte("\x00SLZ")
+)
+
+// compressList gzips the given marshalled list and prefixes it with the magic
+// bytes and the format version, so decompressList can detect it.
+func compressList(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(compressedListMagic)
+	buf.WriteByte(compressedListVersion)
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to compress list")
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to compress list")
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressList returns the marshalled list contained in the given stored
+// bytes. Lists without the magic bytes aren't compressed, so they're returned
+// as they are.
+func decompressList(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressedListMagic) {
+		return data, nil
+	}
+	data = data[len(compressedListMagic):]
+	if len(data) == 0 {
+		return nil, errors.New("compressed list is missing its version")
+	}
+	if data[0] != compressedListVersion {
+		return nil, errors.New(fmt.Sprintf("unsupported compressed list version %d, the latest supported one is %d", data[0], compressedListVersion))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decompress list")
+	}
+	defer zr.Close()
+	b, err := io.ReadAll(io.LimitReader(zr, maxDecompressedListSize+1))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decompress list")
+	}
+	if len(b) > maxDecompressedListSize {
+		return nil, errors.New(fmt.Sprintf("decompressed list exceeds %d bytes", maxDecompressedListSize))
+	}
+	return b, nil
+}
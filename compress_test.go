@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestCompressedList verifies that compressed and uncompressed lists both
+// round-trip through SkyDB, that SERVERLIST_COMPRESS selects the format and
+// that we reject compressed lists we can't read.
+func TestCompressedList(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_COMPRESS", "true")
+	cfg, err := getConfig()
+	if err != nil || !cfg.Compress {
+		t.Fatalf("expected compression to be enabled, got %v", err)
+	}
+	t.Setenv("SERVERLIST_COMPRESS", "sometimes")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected an invalid value to be rejected")
+	}
+
+	t.Setenv("SERVERLIST_COMPRESS", "")
+	cfg = testConfig(t)
+	list := []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+	}
+	for _, compress := range []bool{false, true} {
+		cfg.Compress = compress
+		db := newFakeDB()
+		err = putServerList(context.Background(), db, list, testTweak, 1, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _, err := readRawList(context.Background(), db, testTweak, cfg.OwnName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(raw, compressedListMagic) != compress {
+			t.Fatalf("compress %t: unexpected stored bytes %q", compress, raw)
+		}
+		read, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(list) || !sameServer(read[0], list[0]) || !sameServer(read[1], list[1]) {
+			t.Fatalf("compress %t: expected %v, got %v", compress, list, read)
+		}
+	}
+
+	data, err := marshalServerList(list, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := compressList(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string][]byte{
+		"no version":      compressedListMagic,
+		"unknown version": append(append([]byte(nil), compressedListMagic...), compressedListVersion+1),
+		"truncated":       compressed[:len(compressed)-4],
+	}
+	for name, b := range invalid {
+		if _, err = decompressList(b); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
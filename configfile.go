@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/joho/godotenv"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ownNameVars are the env vars getConfig reads our names from, in order of
+// precedence.
+var ownNameVars = []string{"SKYNET_SERVER_API", "SERVER_DOMAIN", "PORTAL_DOMAIN"}
+
+type (
+	// fileConfig describes the config file. Since JSON is a subset of YAML,
+	// the file can be in either format. Each field corresponds to an env var,
+	// which takes precedence over it.
+	fileConfig struct {
+		Entropy     string `yaml:"entropy"`
+		Tweak       string `yaml:"tweak"`
+		OwnName     string `yaml:"own_name"`
+		SkydAddress string `yaml:"skyd_address"`
+		APIPassword string `yaml:"api_password"`
+	}
+)
+
+// loadConfigFile reads the config file at path and exports its values as the
+// env vars getConfig reads, unless those are already set. This results in the
+// following order of precedence, from highest to lowest:
+// * the process environment
+// * the .env files
+// * the config file
+// The .env files need to be loaded before calling this, see loadEnvFiles.
+func loadConfigFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.AddContext(err, "failed to read config file")
+	}
+	var fc fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	// Reject unknown fields, so typos don't go unnoticed.
+	dec.KnownFields(true)
+	err = dec.Decode(&fc)
+	if err != nil {
+		return errors.AddContext(err, "failed to parse config file")
+	}
+	vars := map[string]string{
+		"SERVERLIST_ENTROPY": fc.Entropy,
+		"SERVERLIST_TWEAK":   fc.Tweak,
+		"SERVERLIST_SKYD":    fc.SkydAddress,
+		"SIA_API_PASSWORD":   fc.APIPassword,
+	}
+	// Our name can come from any of several env vars, so the file's name
+	// would shadow an env var which getConfig reads after SERVER_DOMAIN. We
+	// only use it when none of them is set.
+	if !anyEnvSet(ownNameVars) {
+		vars["SERVER_DOMAIN"] = fc.OwnName
+	}
+	for name, value := range vars {
+		if value == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		err = os.Setenv(name, value)
+		if err != nil {
+			return errors.AddContext(err, "failed to set "+name)
+		}
+	}
+	return nil
+}
+
+// anyEnvSet returns whether any of the given env vars is set to a non-empty
+// value.
+func anyEnvSet(names []string) bool {
+	for _, name := range names {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEnvFiles reads the given .env files in order and exports their values,
+// unless they are already set in the process environment. Values from later
+// files override the ones from earlier files, so a base file can be followed by
+// per-host overrides. Loading no files at all is fine, we then only rely on the
+// process environment.
+func loadEnvFiles(paths []string) error {
+	vars := make(map[string]string)
+	for _, path := range paths {
+		fileVars, err := godotenv.Read(path)
+		if err != nil {
+			return errors.AddContext(err, "failed to read "+path)
+		}
+		for name, value := range fileVars {
+			vars[name] = value
+		}
+	}
+	for name, value := range vars {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		err := os.Setenv(name, value)
+		if err != nil {
+			return errors.AddContext(err, "failed to set "+name)
+		}
+	}
+	return nil
+}
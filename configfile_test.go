@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// configFileVars are the env vars a config file can set.
+var configFileVars = []string{"SERVERLIST_ENTROPY", "SERVERLIST_TWEAK", "SKYNET_SERVER_API", "SERVER_DOMAIN", "PORTAL_DOMAIN", "SERVERLIST_SKYD", "SIA_API_PASSWORD"}
+
+// unsetConfigEnv unsets the env vars a config file can set for the duration of
+// the test.
+func unsetConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range configFileVars {
+		// t.Setenv restores the original value once the test is done.
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// writeConfigFile writes the given config file into a temporary directory and
+// returns its path.
+func writeConfigFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestConfigFile verifies that the config file provides the values the env
+// doesn't and that the env takes precedence over it.
+func TestConfigFile(t *testing.T) {
+	entropy := hex.EncodeToString(make([]byte, 32))
+	tweak := hex.EncodeToString(testTweak[:])
+	yamlFile := "entropy: " + entropy + "\ntweak: " + tweak + "\nown_name: file.siasky.dev\nskyd_address: localhost:9980\napi_password: file\n"
+	jsonFile := `{"entropy": "` + entropy + `", "tweak": "` + tweak + `", "own_name": "file.siasky.dev", "skyd_address": "localhost:9980", "api_password": "file"}`
+
+	t.Run("file only", func(t *testing.T) {
+		for _, f := range []struct{ name, data string }{{"config.yaml", yamlFile}, {"config.json", jsonFile}} {
+			unsetConfigEnv(t)
+			if err := loadConfigFile(writeConfigFile(t, f.name, f.data)); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := getConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.OwnName != "file.siasky.dev" || cfg.SkydAddress != "localhost:9980" || cfg.SkydApiPassword != "file" || cfg.Tweaks[0] != testTweak {
+				t.Fatalf("%s: unexpected config %+v", f.name, cfg)
+			}
+		}
+	})
+
+	t.Run("env only", func(t *testing.T) {
+		unsetConfigEnv(t)
+		setTestEnv(t)
+		cfg, err := getConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.OwnName != "dev1.siasky.dev" || cfg.SkydApiPassword != "password" {
+			t.Fatalf("unexpected config %+v", cfg)
+		}
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		unsetConfigEnv(t)
+		t.Setenv("SKYNET_SERVER_API", "env.siasky.dev")
+		t.Setenv("SIA_API_PASSWORD", "env")
+		if err := loadConfigFile(writeConfigFile(t, "config.yaml", yamlFile)); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := getConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.OwnName != "env.siasky.dev" || cfg.SkydApiPassword != "env" || cfg.SkydAddress != "localhost:9980" {
+			t.Fatalf("unexpected config %+v", cfg)
+		}
+	})
+
+	t.Run("fallback name", func(t *testing.T) {
+		unsetConfigEnv(t)
+		t.Setenv("PORTAL_DOMAIN", "portal.siasky.dev")
+		if err := loadConfigFile(writeConfigFile(t, "config.yaml", yamlFile)); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := getConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.OwnName != "portal.siasky.dev" {
+			t.Fatalf("expected the env name to win, got %s", cfg.OwnName)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		unsetConfigEnv(t)
+		if err := loadConfigFile(writeConfigFile(t, "config.yaml", "entropi: abc\n")); err == nil {
+			t.Fatal("expected an unknown field to be rejected")
+		}
+	})
+}
+
+// TestEnvFiles verifies that we get by without .env files, that a single file
+// is loaded, that later files override earlier ones and that the process
+// environment overrides all of them.
+func TestEnvFiles(t *testing.T) {
+	unsetConfigEnv(t)
+
+	// Without files, only the process environment counts.
+	t.Setenv("SKYNET_SERVER_API", "env.siasky.dev")
+	if err := loadEnvFiles(nil); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SKYNET_SERVER_API") != "env.siasky.dev" {
+		t.Fatalf("unexpected SKYNET_SERVER_API %q", os.Getenv("SKYNET_SERVER_API"))
+	}
+
+	unsetConfigEnv(t)
+	base := writeConfigFile(t, "base.env", "SKYNET_SERVER_API=base.siasky.dev\nSERVERLIST_SKYD=localhost:9980\n")
+	if err := loadEnvFiles([]string{base}); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SKYNET_SERVER_API") != "base.siasky.dev" || os.Getenv("SERVERLIST_SKYD") != "localhost:9980" {
+		t.Fatalf("unexpected env %q and %q", os.Getenv("SKYNET_SERVER_API"), os.Getenv("SERVERLIST_SKYD"))
+	}
+
+	unsetConfigEnv(t)
+	host := writeConfigFile(t, "host.env", "SKYNET_SERVER_API=host.siasky.dev\n")
+	if err := loadEnvFiles([]string{base, host}); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SKYNET_SERVER_API") != "host.siasky.dev" || os.Getenv("SERVERLIST_SKYD") != "localhost:9980" {
+		t.Fatalf("unexpected env %q and %q", os.Getenv("SKYNET_SERVER_API"), os.Getenv("SERVERLIST_SKYD"))
+	}
+
+	unsetConfigEnv(t)
+	t.Setenv("SKYNET_SERVER_API", "env.siasky.dev")
+	if err := loadEnvFiles([]string{base, host}); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SKYNET_SERVER_API") != "env.siasky.dev" {
+		t.Fatalf("unexpected SKYNET_SERVER_API %q", os.Getenv("SKYNET_SERVER_API"))
+	}
+
+	if err := loadEnvFiles([]string{filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
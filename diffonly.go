@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// diffOnly computes the list we would write to SkyDB under the given tweak and
+// reports whether it differs from the stored list, without writing anything.
+// It prints "no change" or the diff and returns whether the list would change.
+// The announce time of our own records changes on every announce, so we ignore
+// it, see ignoreAnnounceBump.
+func diffOnly(ctx context.Context, db skyDB, skyd skydClient, cfg config, tweak [32]byte, getIP func(context.Context) (string, error)) (bool, error) {
+	stored, planned, _, err := planList(ctx, db, skyd, cfg, tweak, getIP)
+	if err != nil {
+		return false, err
+	}
+	added, updated, removed := diffLists(stored, ignoreAnnounceBump(stored, planned))
+	if len(added)+len(updated)+len(removed) == 0 {
+		fmt.Println("no change")
+		return false, nil
+	}
+	printDiff("added", added)
+	printDiff("updated", updated)
+	printDiff("removed", removed)
+	return true, nil
+}
+
+// ignoreAnnounceBump returns a copy of the planned list in which the servers
+// that are also on the stored list keep their stored announce time, so that
+// comparing the two lists only shows changes which actually matter.
+func ignoreAnnounceBump(stored, planned []server) []server {
+	storedByKey := make(map[serverKey]server, len(stored))
+	for _, s := range stored {
+		storedByKey[s.key()] = s
+	}
+	list := append([]server(nil), planned...)
+	for i, s := range list {
+		if old, exists := storedByKey[s.key()]; exists {
+			// The signature covers the announce time, so it changes
+			// along with it.
+			list[i].LastAnnounce = old.LastAnnounce
+			list[i].Signature = old.Signature
+		}
+	}
+	return list
+}
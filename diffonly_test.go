@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+)
+
+// TestDiffOnly verifies that -diff-only reports no change when the stored list
+// already reflects our state, apart from the announce time, that it reports a
+// change otherwise and that it never writes.
+func TestDiffOnly(t *testing.T) {
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	skyd := newFakeSkyd()
+	if _, err := announce(context.Background(), db, skyd, cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	writes := db.writeCount()
+
+	changed, err := diffOnly(context.Background(), db, skyd, cfg, testTweak, staticIP("1.1.1.1"))
+	if err != nil || changed {
+		t.Fatalf("expected no change, got %t and %v", changed, err)
+	}
+	opts := options{diffOnly: true}
+	if code := run(context.Background(), cfg, db, skyd, pk, staticIP("1.1.1.1"), opts); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+
+	changed, err = diffOnly(context.Background(), db, skyd, cfg, testTweak, staticIP("2.2.2.2"))
+	if err != nil || !changed {
+		t.Fatalf("expected a new ip to be a change, got %t and %v", changed, err)
+	}
+	skyd.version = "1.5.11"
+	changed, err = diffOnly(context.Background(), db, skyd, cfg, testTweak, staticIP("1.1.1.1"))
+	if err != nil || !changed {
+		t.Fatalf("expected a new version to be a change, got %t and %v", changed, err)
+	}
+	if code := run(context.Background(), cfg, db, skyd, pk, staticIP("1.1.1.1"), opts); code != exitChanged {
+		t.Fatalf("expected exit code %d, got %d", exitChanged, code)
+	}
+
+	if db.writeCount() != writes {
+		t.Fatalf("expected no writes, got %d", db.writeCount()-writes)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// The exit codes of the tool, which allow scripts to tell failures apart.
+const (
+	// exitSuccess means that the tool did what it was asked to do.
+	exitSuccess = 0
+	// exitFailure covers all failures without a more specific exit code,
+	// including being interrupted by a shutdown signal.
+	exitFailure = 1
+	// exitConfig means that the configuration is invalid.
+	exitConfig = 2
+	// exitAuth means that skyd rejected our API password.
+	exitAuth = 3
+	// exitNetwork means that we gave up after transient errors, e.g. because
+	// skyd or SkyDB were unreachable.
+	exitNetwork = 4
+	// exitConflict means that we gave up because other servers kept updating
+	// the list under us.
+	exitConflict = 5
+	// exitChanged means that -diff-only found that an announce would change
+	// the list.
+	exitChanged = 6
+)
+
+// exitCode returns the exit code for the given error of a failed operation
+// against SkyDB.
+func exitCode(err error) int {
+	switch classifyError(err) {
+	case errAuth:
+		return exitAuth
+	case errConflict:
+		return exitConflict
+	case errRefused:
+		return exitFailure
+	default:
+		return exitNetwork
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+)
+
+// TestExitCodes verifies that run maps each kind of failure to its exit code.
+func TestExitCodes(t *testing.T) {
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}
+	tests := []struct {
+		name  string
+		setup func(cfg *config, db *fakeDB, opts *options)
+		code  int
+	}{
+		{"success", func(*config, *fakeDB, *options) {}, exitSuccess},
+		{"config", func(_ *config, _ *fakeDB, opts *options) {
+			opts.evict = other.Name
+		}, exitConfig},
+		{"auth", func(_ *config, db *fakeDB, _ *options) {
+			db.onRead = func(int) error { return errors.New("[" + skydAuthError + "]") }
+		}, exitAuth},
+		{"network", func(_ *config, db *fakeDB, _ *options) {
+			db.onRead = func(int) error { return errors.New("dial tcp 127.0.0.1:9980: connection refused") }
+		}, exitNetwork},
+		{"conflict", func(_ *config, db *fakeDB, _ *options) {
+			db.onWrite = func(int) error { return errors.AddContext(modules.ErrLowerRevNum, "failed to update registry") }
+		}, exitConflict},
+		{"refused", func(cfg *config, _ *fakeDB, _ *options) {
+			cfg.MaxServers = 1
+		}, exitFailure},
+		{"changed", func(_ *config, _ *fakeDB, opts *options) {
+			opts.diffOnly = true
+		}, exitChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+			db := newFakeDB()
+			db.storeList(t, testTweak, []server{other})
+			opts := options{output: outputText, noSpread: true}
+			tt.setup(&cfg, db, &opts)
+			if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code != tt.code {
+				t.Fatalf("expected exit code %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ro-tex/skydb"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	siaapi "go.sia.tech/siad/node/api"
+)
+
+var (
+	// testTweak is the tweak of the list the tests announce to.
+	testTweak = [32]byte{1, 2, 3, 4}
+	// testTime is the time at which the tests announce.
+	testTime = time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+)
+
+type (
+	// fakeDB is an in-memory skyDB. Like the registry, it rejects writes which
+	// don't increase the revision of an entry.
+	// * onRead and onWrite are called before each read and write with the
+	// number of the call, starting at 1. When they return an error, the call
+	// fails with it. Tests use them to inject failures, delays, panics and the
+	// writes of other servers.
+	fakeDB struct {
+		entries map[crypto.Hash]fakeEntry
+		reads   int
+		writes  int
+		onRead  func(n int) error
+		onWrite func(n int) error
+		mu      sync.Mutex
+	}
+
+	// fakeEntry is a single entry of the fakeDB.
+	fakeEntry struct {
+		data []byte
+		rev  uint64
+	}
+
+	// fakeSkyd is a skydClient which answers every call from its fields. When
+	// err is set, every call fails with it.
+	fakeSkyd struct {
+		ready    bool
+		version  string
+		gateway  modules.NetAddress
+		skylinks map[string][]byte
+		err      error
+	}
+)
+
+// newFakeDB returns an empty fakeDB.
+func newFakeDB() *fakeDB {
+	return &fakeDB{entries: make(map[crypto.Hash]fakeEntry)}
+}
+
+// Read implements skyDB.
+func (f *fakeDB) Read(tweak crypto.Hash) ([]byte, uint64, error) {
+	f.mu.Lock()
+	f.reads++
+	n, hook := f.reads, f.onRead
+	f.mu.Unlock()
+	if hook != nil {
+		if err := hook(n); err != nil {
+			return nil, 0, err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, exists := f.entries[tweak]
+	if !exists {
+		return nil, 0, skydb.ErrNotFound
+	}
+	return e.data, e.rev, nil
+}
+
+// Write implements skyDB.
+func (f *fakeDB) Write(data []byte, tweak crypto.Hash, rev uint64) error {
+	f.mu.Lock()
+	f.writes++
+	n, hook := f.writes, f.onWrite
+	f.mu.Unlock()
+	if hook != nil {
+		if err := hook(n); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, exists := f.entries[tweak]; exists && rev <= e.rev {
+		return errors.AddContext(modules.ErrLowerRevNum, "failed to update registry")
+	}
+	f.entries[tweak] = fakeEntry{data: data, rev: rev}
+	return nil
+}
+
+// storeList stores the given list under the given tweak at the next revision,
+// as if another server wrote it. It bypasses the hooks.
+func (f *fakeDB) storeList(t *testing.T, tweak [32]byte, list []server) {
+	t.Helper()
+	data, err := marshalServerList(list, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.storeRaw(tweak, data)
+}
+
+// storeRaw stores the given bytes under the given tweak at the next revision.
+// It bypasses the hooks.
+func (f *fakeDB) storeRaw(tweak [32]byte, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[tweak] = fakeEntry{data: data, rev: f.entries[tweak].rev + 1}
+}
+
+// storedList returns the list stored under the given tweak and its revision.
+// It bypasses the hooks.
+func (f *fakeDB) storedList(t *testing.T, tweak [32]byte) ([]server, uint64) {
+	t.Helper()
+	f.mu.Lock()
+	e, exists := f.entries[tweak]
+	f.mu.Unlock()
+	if !exists {
+		t.Fatalf("list %s doesn't exist", tweakID(tweak))
+	}
+	list, err := unmarshalServerList(e.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list, e.rev
+}
+
+// writeCount returns the number of writes the fakeDB received so far,
+// including the failed ones.
+func (f *fakeDB) writeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes
+}
+
+// readCount returns the number of reads the fakeDB received so far, including
+// the failed ones.
+func (f *fakeDB) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+// newFakeSkyd returns a fakeSkyd of a healthy skyd with a public gateway
+// address.
+func newFakeSkyd() *fakeSkyd {
+	return &fakeSkyd{
+		ready:    true,
+		version:  "1.5.10",
+		gateway:  "8.8.4.4:9981",
+		skylinks: make(map[string][]byte),
+	}
+}
+
+// DaemonReadyGet implements skydClient.
+func (f *fakeSkyd) DaemonReadyGet() (api.DaemonReady, error) {
+	if f.err != nil {
+		return api.DaemonReady{}, f.err
+	}
+	return api.DaemonReady{Ready: f.ready}, nil
+}
+
+// DaemonVersionGet implements skydClient.
+func (f *fakeSkyd) DaemonVersionGet() (api.DaemonVersionGet, error) {
+	if f.err != nil {
+		return api.DaemonVersionGet{}, f.err
+	}
+	return api.DaemonVersionGet{Version: f.version}, nil
+}
+
+// SkynetSkylinkGet implements skydClient.
+func (f *fakeSkyd) SkynetSkylinkGet(skylink string) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	data, exists := f.skylinks[skylink]
+	if !exists {
+		return nil, errors.New("skylink not found")
+	}
+	return data, nil
+}
+
+// RenterBackups implements skydClient.
+func (f *fakeSkyd) RenterBackups() (api.RenterBackupsGET, error) {
+	if f.err != nil {
+		return api.RenterBackupsGET{}, f.err
+	}
+	return api.RenterBackupsGET{}, nil
+}
+
+// GatewayGet implements skydClient.
+func (f *fakeSkyd) GatewayGet() (siaapi.GatewayGET, error) {
+	if f.err != nil {
+		return siaapi.GatewayGET{}, f.err
+	}
+	return siaapi.GatewayGET{NetAddress: f.gateway}, nil
+}
+
+// setTestEnv sets the env vars getConfig requires to valid values for the
+// duration of the test.
+func setTestEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("SKYNET_SERVER_API", "dev1.siasky.dev")
+	t.Setenv("SERVERLIST_ENTROPY", hex.EncodeToString(make([]byte, 32)))
+	t.Setenv("SERVERLIST_TWEAK", hex.EncodeToString(testTweak[:]))
+	t.Setenv("SIA_API_PASSWORD", "password")
+}
+
+// testConfig returns the config getConfig returns for the env set by
+// setTestEnv, with all delays shortened, so the tests run fast.
+func testConfig(t *testing.T) config {
+	t.Helper()
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.StabilizeDelay = 0
+	cfg.CheckPollDelay = time.Millisecond
+	cfg.BackoffBase = time.Millisecond
+	cfg.BackoffMax = 10 * time.Millisecond
+	cfg.Spread = 0
+	cfg.MaxAttempts = 3
+	cfg.IPCacheTTL = 0
+	return cfg
+}
+
+// setClock stops the clock at the given time for the duration of the test.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	old := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = old })
+}
+
+// staticIP returns an IP discovery function which always discovers the given
+// IP.
+func staticIP(ip string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		return ip, nil
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/SkynetLabs/servers
 
-go 1.18
+go 1.21
 
 require (
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f // indirect
@@ -23,6 +23,8 @@ require (
 	golang.org/x/text v0.3.7 // indirect
 )
 
+require gopkg.in/yaml.v3 v3.0.1
+
 require (
 	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da // indirect
 	github.com/dchest/threefish v0.0.0-20120919164726-3ecf4c494abf // indirect
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// maxFutureAnnounce is how far in the future we accept announce times,
+	// which allows for some clock skew between servers.
+	maxFutureAnnounce = time.Hour
+)
+
+// readImportFile reads the servers we want to import from the given JSON file,
+// which holds an array of server records. Every record must be valid, see
+// validateServer.
+func readImportFile(path string, trusted map[string]crypto.PublicKey) ([]server, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read import file")
+	}
+	var servers []server
+	err = json.Unmarshal(b, &servers)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse import file, it must contain an array of servers")
+	}
+	for i, s := range servers {
+		err = validateServer(s, trusted)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("invalid server at index %d", i))
+		}
+	}
+	return servers, nil
+}
+
+// validateServer checks that the given record is one we could have written
+// ourselves. Its signature must verify against the given trusted keys, see
+// verifyRecord. Unlike the lists we read, records we import must not contain
+// fields we don't know about, since those are most likely typos.
+func validateServer(s server, trusted map[string]crypto.PublicKey) error {
+	if s.Name == "" {
+		return errors.New("missing name")
+	}
+	if _, _, err := parseOwnName(s.Name); err != nil {
+		return errors.AddContext(err, "invalid name")
+	}
+	if s.ID != "" && !uuidRegex.MatchString(s.ID) {
+		return errors.New(fmt.Sprintf("id '%s' is not a UUID", s.ID))
+	}
+	if s.IP != "" {
+		if _, err := parseIP(s.IP); err != nil {
+			return errors.AddContext(err, "invalid ip")
+		}
+	}
+	for _, ip := range s.IPs {
+		if _, err := parseIP(ip); err != nil {
+			return errors.AddContext(err, "invalid ips")
+		}
+	}
+	if s.LastAnnounce.IsZero() {
+		return errors.New("missing last_announce")
+	}
+	if s.LastAnnounce.After(clock().Add(maxFutureAnnounce)) {
+		return errors.New(fmt.Sprintf("last_announce %s is in the future", s.LastAnnounce.Format(time.RFC3339)))
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return errors.New(fmt.Sprintf("port %d is out of range", s.Port))
+	}
+	if err := verifyRecord(s, trusted); err != nil {
+		return err
+	}
+	for k, v := range s.Labels {
+		if k == "" || v == "" {
+			return errors.New("labels must have a non-empty key and value")
+		}
+	}
+	if len(s.Extra) > 0 {
+		var fields []string
+		for k := range s.Extra {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		return errors.New(fmt.Sprintf("unknown fields %v", fields))
+	}
+	return nil
+}
+
+// importServers merges the given servers into the list under the given tweak.
+// A server which is already on the list keeps whichever of its records has the
+// most recent announce. It returns the number of added or updated servers and
+// retries just like announce does.
+func importServers(ctx context.Context, db skyDB, cfg config, tweak [32]byte, servers []server, m *metrics) (int, error) {
+	imported := 0
+	err := withRetries(ctx, cfg, m, func(ctx context.Context, l *slog.Logger) error {
+		var err error
+		imported, err = importAttempt(ctx, db, cfg, tweak, servers, l)
+		return err
+	})
+	return imported, err
+}
+
+// importAttempt makes a single attempt to merge the given servers into the
+// list under the given tweak. If the merge doesn't change the list, it returns
+// zero without writing anything.
+func importAttempt(ctx context.Context, db skyDB, cfg config, tweak [32]byte, servers []server, l *slog.Logger) (int, error) {
+	list, rev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		l.Error("failed to get server list", "error", err)
+		return 0, err
+	}
+	l = l.With("revision", rev)
+	merged := dedupServers(append(append([]server(nil), list...), servers...))
+	added, updated, _ := diffLists(list, merged)
+	changed := len(added) + len(updated)
+	if changed == 0 {
+		l.Info("the list already contains all imported servers, nothing to import")
+		return 0, nil
+	}
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	err = putServerList(writeCtx, db, merged, tweak, rev+1, cfg)
+	if err != nil {
+		l.Error("failed to update server list", "servers", len(merged), "error", err)
+		return 0, err
+	}
+	// Give the system time to stabilize before we check, see announceAttempt.
+	if !sleep(ctx, cfg.StabilizeDelay) {
+		return 0, ctx.Err()
+	}
+	list, _, err = getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		l.Error("failed to get server list", "error", err)
+		return 0, err
+	}
+	// Other servers may have announced since our write, so we only check that
+	// the list is at least as recent as the servers we imported.
+	latest := make(map[serverKey]server, len(list))
+	for _, s := range list {
+		latest[s.key()] = s
+	}
+	for _, s := range append(added, updated...) {
+		if cur, exists := latest[s.key()]; !exists || cur.LastAnnounce.Before(s.LastAnnounce) {
+			l.Warn("imported server is missing from the list", "name", s.Name)
+			return 0, errors.New("imported servers are missing from the list")
+		}
+	}
+	l.Info("imported servers into the list", "added", len(added), "updated", len(updated), "servers", len(merged))
+	return changed, nil
+}
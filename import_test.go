@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestImport verifies that we only import valid files and that imported
+// servers are merged into the list, keeping the most recent record of each
+// server.
+func TestImport(t *testing.T) {
+	setClock(t, testTime)
+	invalid := map[string]string{
+		"not an array":  `{"servers":[]}`,
+		"missing name":  `[{"ip":"1.1.1.1","last_announce":"2022-06-01T00:00:00Z"}]`,
+		"invalid ip":    `[{"name":"a.siasky.dev","ip":"1.1.1","last_announce":"2022-06-01T00:00:00Z"}]`,
+		"no announce":   `[{"name":"a.siasky.dev","ip":"1.1.1.1"}]`,
+		"future":        `[{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"2023-06-01T00:00:00Z"}]`,
+		"unknown field": `[{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"2022-06-01T00:00:00Z","naem":"b"}]`,
+	}
+	for name, data := range invalid {
+		if _, err := readImportFile(writeConfigFile(t, "import.json", data), nil); err == nil {
+			t.Fatalf("%s: expected the file to be rejected", name)
+		}
+	}
+
+	path := writeConfigFile(t, "import.json", `[
+		{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"2022-06-01T10:00:00Z"},
+		{"name":"b.siasky.dev","ip":"2.2.2.2","last_announce":"2022-06-01T10:00:00Z"}
+	]`)
+	servers, err := readImportFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %v", servers)
+	}
+
+	// Into an empty list.
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{})
+	imported, err := importServers(context.Background(), db, cfg, testTweak, servers, newMetrics())
+	if err != nil || imported != 2 {
+		t.Fatalf("expected 2 imported servers, got %d and %v", imported, err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 2 {
+		t.Fatalf("unexpected list %v", stored)
+	}
+	// Importing again doesn't change anything, so we don't write.
+	writes := db.writeCount()
+	if imported, err = importServers(context.Background(), db, cfg, testTweak, servers, newMetrics()); err != nil || imported != 0 {
+		t.Fatalf("expected nothing to import, got %d and %v", imported, err)
+	}
+	if db.writeCount() != writes {
+		t.Fatal("expected no write")
+	}
+
+	// Into an existing list, where a.siasky.dev has announced since the
+	// export and b.siasky.dev hasn't.
+	newer := testTime.Add(-time.Hour)
+	older := time.Date(2022, time.June, 1, 8, 0, 0, 0, time.UTC)
+	db = newFakeDB()
+	db.storeList(t, testTweak, []server{
+		{Name: "a.siasky.dev", IP: "5.5.5.5", LastAnnounce: newer},
+		{Name: "b.siasky.dev", IP: "6.6.6.6", LastAnnounce: older},
+		{Name: "c.siasky.dev", IP: "7.7.7.7", LastAnnounce: newer},
+	})
+	imported, err = importServers(context.Background(), db, cfg, testTweak, servers, newMetrics())
+	if err != nil || imported != 1 {
+		t.Fatalf("expected 1 imported server, got %d and %v", imported, err)
+	}
+	stored, _ = db.storedList(t, testTweak)
+	want := map[string]string{"a.siasky.dev": "5.5.5.5", "b.siasky.dev": "2.2.2.2", "c.siasky.dev": "7.7.7.7"}
+	if len(stored) != len(want) {
+		t.Fatalf("unexpected list %v", stored)
+	}
+	for _, s := range stored {
+		if want[s.Name] != s.IP {
+			t.Fatalf("expected %s at %s, got %s", s.Name, want[s.Name], s.IP)
+		}
+	}
+}
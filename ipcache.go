@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// ipCacheEntry is the on-disk representation of the last IP we
+	// discovered. The endpoint identifies the IP providers we used.
+	ipCacheEntry struct {
+		Endpoint  string    `json:"endpoint"`
+		IP        string    `json:"ip"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+)
+
+// cachedIP returns the IP cached in the file at path, as long as it was
+// discovered via the same endpoint less than ttl ago. Otherwise, or when
+// refresh is set, it discovers the IP via lookup and caches it. Failing to
+// read or write the cache is not an error, we just fall back to the lookup.
+func cachedIP(path, endpoint string, ttl time.Duration, refresh bool, lookup func() (string, error)) (string, error) {
+	if !refresh {
+		entry, err := readIPCache(path)
+		// A timestamp in the future means that the clock was off when we
+		// wrote the entry, so we can't tell its age and treat it as expired.
+		age := time.Since(entry.Timestamp)
+		if err == nil && entry.Endpoint == endpoint && age >= 0 && age < ttl {
+			logger.Debug("using cached ip", "ip", entry.IP, "age", age)
+			return entry.IP, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to read ip cache", "path", path, "error", err)
+		}
+	}
+	ip, err := lookup()
+	if err != nil {
+		return "", err
+	}
+	entry := ipCacheEntry{
+		Endpoint:  endpoint,
+		IP:        ip,
+		Timestamp: time.Now(),
+	}
+	err = writeIPCache(path, entry)
+	if err != nil {
+		logger.Warn("failed to write ip cache", "path", path, "error", err)
+	}
+	return ip, nil
+}
+
+// readIPCache reads the cached IP from the file at path.
+func readIPCache(path string) (ipCacheEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ipCacheEntry{}, err
+	}
+	var entry ipCacheEntry
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		return ipCacheEntry{}, errors.AddContext(err, "failed to unmarshal ip cache")
+	}
+	return entry, nil
+}
+
+// writeIPCache stores the given entry in the file at path.
+func writeIPCache(path string, entry ipCacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal ip cache")
+	}
+	return os.WriteFile(path, b, 0600)
+}
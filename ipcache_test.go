@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestCachedIP verifies that we reuse a fresh cached IP and look the IP up on a
+// cache miss, when the cache expired or is from the future, when the providers
+// changed and when a refresh is forced.
+func TestCachedIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip.json")
+	lookups := 0
+	lookup := func(ip string) func() (string, error) {
+		return func() (string, error) {
+			lookups++
+			return ip, nil
+		}
+	}
+	check := func(got, want string, err error, wantLookups int) {
+		t.Helper()
+		if err != nil || got != want || lookups != wantLookups {
+			t.Fatalf("expected %s after %d lookups, got %s after %d lookups and %v", want, wantLookups, got, lookups, err)
+		}
+	}
+
+	// miss
+	ip, err := cachedIP(path, "ipify", time.Hour, false, lookup("1.1.1.1"))
+	check(ip, "1.1.1.1", err, 1)
+	// hit
+	ip, err = cachedIP(path, "ipify", time.Hour, false, lookup("2.2.2.2"))
+	check(ip, "1.1.1.1", err, 1)
+	// other providers
+	ip, err = cachedIP(path, "icanhazip", time.Hour, false, lookup("2.2.2.2"))
+	check(ip, "2.2.2.2", err, 2)
+	// forced refresh
+	ip, err = cachedIP(path, "icanhazip", time.Hour, true, lookup("3.3.3.3"))
+	check(ip, "3.3.3.3", err, 3)
+	// expired
+	err = writeIPCache(path, ipCacheEntry{Endpoint: "icanhazip", IP: "3.3.3.3", Timestamp: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err = cachedIP(path, "icanhazip", time.Hour, false, lookup("4.4.4.4"))
+	check(ip, "4.4.4.4", err, 4)
+	// from the future
+	err = writeIPCache(path, ipCacheEntry{Endpoint: "icanhazip", IP: "4.4.4.4", Timestamp: time.Now().Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err = cachedIP(path, "icanhazip", time.Hour, false, lookup("5.5.5.5"))
+	check(ip, "5.5.5.5", err, 5)
+
+	// A failed lookup isn't cached.
+	_, err = cachedIP(path, "icanhazip", time.Hour, true, func() (string, error) {
+		return "", errors.New("all ip providers failed")
+	})
+	if err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	entry, err := readIPCache(path)
+	if err != nil || entry.IP != "5.5.5.5" {
+		t.Fatalf("expected the cache to keep 5.5.5.5, got %v and %v", entry, err)
+	}
+}
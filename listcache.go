@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+)
+
+type (
+	// listCache holds the last list we successfully read or wrote under each
+	// tweak, together with its revision. When a read fails transiently, we can
+	// still announce by applying our record to the cached list. A nil
+	// listCache caches nothing.
+	listCache struct {
+		lists map[[32]byte]cachedList
+		mu    sync.Mutex
+	}
+
+	// cachedList is a list in the listCache.
+	cachedList struct {
+		list []server
+		rev  uint64
+	}
+)
+
+// newListCache returns an empty listCache.
+func newListCache() *listCache {
+	return &listCache{
+		lists: make(map[[32]byte]cachedList),
+	}
+}
+
+// store caches a copy of the given list, which has the given revision.
+func (c *listCache) store(tweak [32]byte, list []server, rev uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lists[tweak] = cachedList{
+		list: append([]server(nil), list...),
+		rev:  rev,
+	}
+}
+
+// load returns a copy of the cached list under the given tweak and its
+// revision. It returns false if there's no such list.
+func (c *listCache) load(tweak [32]byte) ([]server, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cl, exists := c.lists[tweak]
+	if !exists {
+		return nil, 0, false
+	}
+	return append([]server(nil), cl.list...), cl.rev, true
+}
+
+// drop removes the cached list under the given tweak, e.g. because we know
+// that it's outdated.
+func (c *listCache) drop(tweak [32]byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.lists, tweak)
+}
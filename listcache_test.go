@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestListCacheFallback verifies that a transiently failing read falls back to
+// the last list we know, so we still announce without losing the records of
+// other servers, and that other failures don't.
+func TestListCacheFallback(t *testing.T) {
+	cfg := testConfig(t)
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{other})
+	lc := newListCache()
+	if _, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), lc); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := lc.load(testTweak); !ok {
+		t.Fatal("expected the list to be cached")
+	}
+
+	// Only the first read of the next announce fails.
+	var readErr atomic.Value
+	var failed atomic.Bool
+	db.onRead = func(int) error {
+		if err, ok := readErr.Load().(error); ok && failed.CompareAndSwap(false, true) {
+			return err
+		}
+		return nil
+	}
+	readErr.Store(errors.New("connection refused"))
+	m := newMetrics()
+	if _, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, lc); err != nil {
+		t.Fatal(err)
+	}
+	if m.attempts != 1 {
+		t.Fatalf("expected the cached list to save an attempt, got %d attempts", m.attempts)
+	}
+	stored, rev := db.storedList(t, testTweak)
+	if rev != 3 || len(stored) != 2 || ownRecordIndex(stored, other.Name, "") < 0 {
+		t.Fatalf("expected both records at revision 3, got %v at %d", stored, rev)
+	}
+
+	// skyd rejecting our password is not something the cache can help with.
+	readErr.Store(errors.New("[" + skydAuthError + "]"))
+	failed.Store(false)
+	m = newMetrics()
+	if _, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, lc); !errors.Contains(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	if _, rev = db.storedList(t, testTweak); rev != 3 {
+		t.Fatalf("expected no write, got revision %d", rev)
+	}
+}
+
+// TestListCacheConcurrency verifies that the cache is safe for concurrent use
+// and that it hands out copies.
+func TestListCacheConcurrency(t *testing.T) {
+	lc := newListCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lc.store(testTweak, []server{{Name: "a.siasky.dev"}}, uint64(i))
+			if list, _, ok := lc.load(testTweak); ok {
+				list[0].Name = "changed.siasky.dev"
+			}
+		}(i)
+	}
+	wg.Wait()
+	list, _, ok := lc.load(testTweak)
+	if !ok || list[0].Name != "a.siasky.dev" {
+		t.Fatalf("unexpected cached list %v", list)
+	}
+	lc.drop(testTweak)
+	if _, _, ok = lc.load(testTweak); ok {
+		t.Fatal("expected the list to be dropped")
+	}
+	var nilCache *listCache
+	nilCache.store(testTweak, list, 1)
+	if _, _, ok = nilCache.load(testTweak); ok {
+		t.Fatal("expected a nil cache to cache nothing")
+	}
+}
@@ -1,279 +1,3159 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math"
-	"math/rand"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/ro-tex/skydb"
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/NebulousLabs/fastrand"
+	"gitlab.com/SkynetLabs/skyd/node/api"
 	"gitlab.com/SkynetLabs/skyd/node/api/client"
 	"gitlab.com/SkynetLabs/skyd/skymodules"
 	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	siaapi "go.sia.tech/siad/node/api"
 	"go.sia.tech/siad/types"
 )
 
+const (
+	// listVersion is the version of the format in which we store the list.
+	// Version 0 is the legacy format, which is a bare JSON array of servers.
+	listVersion = 1
+
+	// defaultUserAgent is the user agent we use when talking to skyd, unless
+	// configured otherwise.
+	defaultUserAgent = "Sia-Agent"
+
+	// defaultPruneAfter is the default time after which we remove servers
+	// that haven't announced themselves from the list.
+	defaultPruneAfter = 7 * 24 * time.Hour
+
+	// ipifyURL is the endpoint we use in order to discover our external IPv4.
+	ipifyURL = "https://api.ipify.org"
+	// ipifyV6URL is the endpoint we use in order to discover our external
+	// IPv6.
+	ipifyV6URL = "https://api6.ipify.org"
+
+	// defaultStabilizeDelay is the default time we wait after writing the
+	// list before we check whether our write persisted.
+	defaultStabilizeDelay = 3 * time.Second
+
+	// defaultSpread is the default window within which we randomly delay
+	// our first announce.
+	defaultSpread = 30 * time.Second
+
+	// defaultSuccessWindow is the default window within which our record
+	// needs to have been updated for an announce to count as successful.
+	defaultSuccessWindow = 5 * time.Minute
+
+	// defaultCheckPolls is the default number of times we read the list
+	// while checking whether our write persisted.
+	defaultCheckPolls = 3
+	// defaultCheckPollDelay is the default base delay between two reads of
+	// the success check.
+	defaultCheckPollDelay = time.Second
+	// maxCheckPollDelay caps the delay between two reads of the success
+	// check.
+	maxCheckPollDelay = 5 * time.Second
+
+	// defaultInterval is the default time between announces in daemon mode.
+	defaultInterval = time.Hour
+	// defaultIntervalJitter is the default fraction of the interval by which
+	// we randomly shorten or lengthen each cycle in daemon mode.
+	defaultIntervalJitter = 0.1
+	// defaultMaxShrink is the default maximum fraction of the servers we read
+	// which a write may drop from the list.
+	defaultMaxShrink = 0.5
+
+	// defaultMaxServers is the default maximum number of servers we write to
+	// the list.
+	defaultMaxServers = 1000
+
+	// skydAuthError is the message with which skyd rejects requests with a
+	// wrong API password.
+	skydAuthError = "API authentication failed"
+
+	// defaultWebhookTimeout is the default maximum duration of a webhook
+	// call.
+	defaultWebhookTimeout = 10 * time.Second
+
+	// defaultAttemptTimeout is the default maximum duration of a single
+	// announce attempt.
+	defaultAttemptTimeout = time.Minute
+
+	// defaultIPCacheTTL is the default time for which we reuse a discovered
+	// IP before looking it up again.
+	defaultIPCacheTTL = time.Hour
+	// defaultIPCacheFile is the name of the default IP cache file, which we
+	// keep in the system's temp dir.
+	defaultIPCacheFile = "serverlist-ip-cache.json"
+
+	// defaultIPTimeout is the default maximum duration of a request to an IP
+	// provider.
+	defaultIPTimeout = 5 * time.Second
+
+	// defaultBackoffBase is the default backoff duration after the first
+	// failed attempt.
+	defaultBackoffBase = time.Second
+	// defaultBackoffMax is the default maximum duration we back off for
+	// between attempts.
+	defaultBackoffMax = 3 * time.Minute
+
+	// outputText is the default, human-readable output format.
+	outputText = "text"
+	// outputJSON is the machine-readable output format.
+	outputJSON = "json"
+)
+
+const (
+	// errTransient errors might go away on their own, so we back off and
+	// retry.
+	errTransient errorClass = iota
+	// errConflict errors mean that the list changed under us, so we retry
+	// right away.
+	errConflict
+	// errAuth errors mean that we're misconfigured, so we don't retry.
+	errAuth
+	// errRefused errors mean that we refused to write the list and retrying
+	// won't change our mind, so we don't retry.
+	errRefused
+)
+
+var (
+	// defaultIPProviders are the services we query in order to discover our
+	// external IPv4, in order of preference.
+	defaultIPProviders = []string{ipifyURL, "https://ifconfig.me/ip", "https://ipv4.icanhazip.com"}
+	// defaultIPv6Providers are the services we query in order to discover our
+	// external IPv6, in order of preference.
+	defaultIPv6Providers = []string{ipifyV6URL, "https://ipv6.icanhazip.com"}
+
+	// hostnameRegex matches valid hostnames, as described in RFC 1123.
+	hostnameRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+	// defaultSchemePorts are the default ports of the schemes of server
+	// names. Names without a scheme are HTTPS.
+	defaultSchemePorts = map[string]int{"http": 80, "https": 443}
+
+	// uuidRegex matches a UUID in its canonical textual form.
+	uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	// ErrMissingOwnName is returned by getConfig when the server name is not
+	// set.
+	ErrMissingOwnName = errors.New("missing server name")
+	// ErrInvalidOwnName is returned by getConfig when the server name is not
+	// a valid host.
+	ErrInvalidOwnName = errors.New("invalid server name")
+	// ErrInvalidPort is returned by getConfig when the server port is not
+	// valid.
+	ErrInvalidPort = errors.New("invalid server port")
+	// ErrInvalidRegion is returned by getConfig when the server region is not
+	// allowed.
+	ErrInvalidRegion = errors.New("invalid server region")
+	// ErrMissingEntropy is returned by getConfig when the entropy is not set.
+	ErrMissingEntropy = errors.New("missing entropy")
+	// ErrInvalidEntropy is returned by getConfig when the entropy is not 32
+	// bytes of hex encoded data.
+	ErrInvalidEntropy = errors.New("invalid entropy")
+	// ErrMissingTweak is returned by getConfig when the tweak is not set.
+	ErrMissingTweak = errors.New("missing tweak")
+	// ErrInvalidTweak is returned by getConfig when a tweak is not 32 bytes
+	// of hex encoded data.
+	ErrInvalidTweak = errors.New("invalid tweak")
+	// ErrMissingAPIPassword is returned by getConfig when the skyd API
+	// password is not set.
+	ErrMissingAPIPassword = errors.New("missing api password")
+
+	// ErrTooManyServers is returned by putServerList when the list exceeds
+	// the maximum number of servers.
+	ErrTooManyServers = errors.New("too many servers on the list")
+
+	// ErrAuthFailed is returned when skyd rejects our API password. Retrying
+	// won't help, so we give up right away.
+	ErrAuthFailed = errors.New("skyd rejected the api password")
+
+	// ErrRevisionConflict is returned when we fail to write the list because
+	// another server has written a revision at least as high as ours since we
+	// read the list.
+	ErrRevisionConflict = errors.New("revision conflict")
+
+	// ErrListShrunk is returned when we refuse to write a list because it's
+	// empty or lost too many of the servers we read, which points to a bug
+	// rather than to legitimate pruning.
+	ErrListShrunk = errors.New("list shrunk too much")
+
+	// logger is where we log our progress. When the output format is JSON we
+	// log to stderr, so stdout only contains the JSON result.
+	logger = newLogger(os.Stdout, slog.LevelInfo, false)
+
+	// clockOffset is the offset of our time source from the local clock in
+	// nanoseconds, see syncClock. It's atomic, since we sync the clock while
+	// the status page reads it.
+	clockOffset atomic.Int64
+	// clock returns the current time, adjusted by clockOffset. The announce
+	// logic uses it instead of calling time.Now directly, so tests can control
+	// the time.
+	clock = func() time.Time {
+		return time.Now().Add(time.Duration(clockOffset.Load()))
+	}
+	// lookupHost resolves a name to its addresses. Tests can replace it in
+	// order to stub out DNS.
+	lookupHost = net.DefaultResolver.LookupHost
+)
+
 type (
+	// errorClass describes how we handle a failed attempt.
+	errorClass int
+
 	// config holds the entire configuration of the tool:
-	// * Entropy and Tweak are the parameters used to access the correct record
-	// in SkyDB. These should be the same on all machines who want to appear on
-	// the same list.
+	// * Entropy and Tweaks are the parameters used to access the correct
+	// records in SkyDB. These should be the same on all machines who want to
+	// appear on the same list. Each tweak corresponds to a separate list.
+	// * BackupTweaks are the tweaks of the backups of the lists, one for each
+	// tweak in Tweaks. They're empty if we don't back up the lists.
 	// * OwnName is the name of the server in the list, e.g. dev1.siasky.dev.
+	// * AliasNames are further names under which we announce the server, each
+	// with a record of its own, e.g. when it serves several portal domains.
+	// * NodeID is the stable identity of the server in the list. When it's
+	// set we identify our record by it instead of by OwnName, so we can rename
+	// the server without leaving its old record behind.
+	// * NodeKey is the seed of the key with which we sign our records. We
+	// don't sign them when it's empty.
+	// * TrustedKeys map server names to the keys which must have signed their
+	// records, see verifyRecord.
+	// * OwnPort is the port on which the server can be reached. Zero means
+	// that it's not announced.
+	// * Region is the geographic region of the server, e.g. us-east.
+	// * Labels are arbitrary key/value pairs we announce, e.g. tier=premium.
+	// When they are nil, we keep the labels of our existing record.
 	// * SkydAddress is the IP:PORT combination on which we can talk to the
 	// local skyd.
+	// * SkydTLS indicates that we should talk TLS to skyd.
+	// * SkydRootCAs are the certificates against which we verify skyd's TLS
+	// certificate. When it's nil we use the system's roots.
 	// * SkydApiPassword is the API password fo the local skyd.
+	// * SkydUserAgent is the user agent we use when talking to skyd.
+	// * Prune indicates that we should prune the list. When it's false, we
+	// keep every server that has ever announced.
+	// * PrunePolicy selects how we prune the list, either prunePolicyTime or
+	// prunePolicyCount.
+	// * PruneAfter is the time after which a server that hasn't announced
+	// itself gets removed from the list by the time policy.
+	// * StaleAfter is the age after which -stale reports a server.
+	// * IPv6 indicates that we should announce our external IPv6 instead of
+	// our IPv4.
+	// * TimeSource is where we take the time of our announces from, either
+	// timeSourceLocal, timeSourceSkyd, or the address of an NTP server.
+	// * IPFromSkyd indicates that we should announce the address skyd's
+	// gateway announces instead of discovering our external IP.
+	// * ResolveName indicates that we should announce all IPs our name
+	// resolves to, e.g. when it points to a load-balanced cluster.
+	// * IP is the external IP we announce. When it's set we neither discover
+	// our IP nor resolve our name.
+	// * IPProviders are the services we query in order to discover our
+	// external IP, in order of preference.
+	// * IPCachePath is the file in which we cache our external IP.
+	// * IPCacheTTL is the time for which we reuse a cached IP. Zero disables
+	// the cache.
+	// * IPTimeout is the maximum duration of a request to an IP provider. It's
+	// independent of AttemptTimeout, so a slow provider can't use up the time
+	// we need for talking to SkyDB.
+	// * StabilizeDelay is the time we wait after writing the list before we
+	// check whether our write persisted.
+	// * SuccessWindow is the window within which our record needs to have
+	// been updated for an announce to count as successful.
+	// * CheckPolls is the number of times we read the list while checking
+	// whether our write persisted, see pollSuccess.
+	// * CheckPollDelay is the base delay between two such reads.
+	// * Spread is the window within which we randomly delay our first
+	// announce, so servers started at the same time don't race each other.
+	// * Interval is the time between announces in daemon mode.
+	// * IntervalJitter is the fraction of the interval by which we randomly
+	// shorten or lengthen each cycle, so servers started together drift apart.
+	// * AttemptTimeout is the maximum duration of a single announce attempt.
+	// * BackoffBase is the time we back off for after the first failed
+	// attempt. It doubles with each further failed attempt.
+	// * BackoffMax is the maximum time we back off for between attempts.
+	// * MaxAttempts is the maximum number of announce attempts we make before
+	// giving up. Zero means that we keep trying until we succeed.
+	// * Reread indicates that we should read the list again right before we
+	// write it and apply our record to the fresh list, if it has changed.
+	// * MaxShrink is the maximum fraction of the servers we read which a write
+	// may drop from the list, see checkShrink.
+	// * AllowShrink indicates that we should write lists regardless of how
+	// much they shrank.
+	// * Compress indicates that we should compress the list when we write it.
+	// * Pretty indicates that we should indent the JSON of the list when we
+	// write it.
+	// * MaxServers is the maximum number of servers we write to the list. It
+	// prevents a runaway list from exceeding the registry's limits.
+	// * MaxBytes is the maximum size of the stored list, after compression.
+	// * OversizeStrategy selects what we do when the list exceeds MaxBytes,
+	// either oversizeFail or oversizeShed, see fitList.
+	// * TrimServers indicates that we should drop the servers with the oldest
+	// announces from lists which exceed MaxServers instead of refusing to write
+	// them.
+	// * MetricsAddr is the address on which we expose Prometheus metrics. The
+	// metrics server is disabled when it's empty.
+	// * StatusAddr is the address on which we expose the JSON status. The
+	// status server is disabled when it's empty.
+	// * StatusToken is the bearer token required to access the status.
+	// * SkylinkFile is the file to which we write the skylinks of our lists
+	// after a successful announce. It's disabled when it's empty.
+	// * WebhookURL is the URL we POST the outcome of each announce to. Webhook
+	// notifications are disabled when it's empty.
+	// * WebhookTimeout is the maximum duration of a webhook call.
+	// * LogLevel is the minimum level of the messages we log.
+	// * LogJSON indicates that we should log in JSON instead of plain text.
 	config struct {
-		Entropy         [32]byte
-		Tweak           [32]byte
-		OwnName         string
-		SkydAddress     string
-		SkydApiPassword string
+		Entropy          [32]byte
+		Tweaks           [][32]byte
+		BackupTweaks     [][32]byte
+		OwnName          string
+		AliasNames       []string
+		NodeID           string
+		NodeKey          []byte
+		TrustedKeys      map[string]crypto.PublicKey
+		OwnPort          int
+		Region           string
+		Labels           map[string]string
+		SkydAddress      string
+		SkydTLS          bool
+		SkydRootCAs      *x509.CertPool
+		SkydApiPassword  string
+		SkydUserAgent    string
+		Prune            bool
+		PrunePolicy      string
+		PruneAfter       time.Duration
+		StaleAfter       time.Duration
+		IPv6             bool
+		IPFromSkyd       bool
+		ResolveName      bool
+		TimeSource       string
+		IP               string
+		IPProviders      []string
+		IPCachePath      string
+		IPCacheTTL       time.Duration
+		IPTimeout        time.Duration
+		StabilizeDelay   time.Duration
+		SuccessWindow    time.Duration
+		CheckPolls       int
+		CheckPollDelay   time.Duration
+		Spread           time.Duration
+		Interval         time.Duration
+		IntervalJitter   float64
+		AttemptTimeout   time.Duration
+		BackoffBase      time.Duration
+		BackoffMax       time.Duration
+		MaxAttempts      int
+		Reread           bool
+		MaxShrink        float64
+		AllowShrink      bool
+		Compress         bool
+		Pretty           bool
+		MaxServers       int
+		MaxBytes         int
+		OversizeStrategy string
+		TrimServers      bool
+		MetricsAddr      string
+		StatusAddr       string
+		StatusToken      string
+		SkylinkFile      string
+		WebhookURL       string
+		WebhookTimeout   time.Duration
+		LogLevel         slog.Level
+		LogJSON          bool
+	}
+
+	// skyDB is the subset of the skydb functionality we need. It allows us to
+	// swap the real SkyDB for an in-memory implementation when testing.
+	skyDB interface {
+		Read(tweak crypto.Hash) ([]byte, uint64, error)
+		Write(data []byte, tweak crypto.Hash, rev uint64) error
+	}
+
+	// skydClient is the subset of the skyd API client we use. It allows us to
+	// stub skyd when testing.
+	skydClient interface {
+		DaemonReadyGet() (api.DaemonReady, error)
+		DaemonVersionGet() (api.DaemonVersionGet, error)
+		SkynetSkylinkGet(skylink string) ([]byte, error)
+		RenterBackups() (api.RenterBackupsGET, error)
+		GatewayGet() (siaapi.GatewayGET, error)
 	}
 
 	// server describes the information we collect for each server on the list.
+	// ID is only set by servers which have a stable node ID, otherwise the
+	// Name identifies the server.
+	// PublicKey and Signature are set by servers which sign their records,
+	// see signRecord.
+	// Aliases are the names under which the server announces further records,
+	// see upsertAliases. Only the server's own record lists them.
+	// Extra holds the fields we don't know about, e.g. ones added by a newer
+	// version of the tool, so we can preserve them when rewriting the list.
 	server struct {
-		Name         string    `json:"name"`
-		IP           string    `json:"ip"`
-		LastAnnounce time.Time `json:"last_announce"`
+		ID           string            `json:"id,omitempty"`
+		Name         string            `json:"name"`
+		IP           string            `json:"ip"`
+		IPs          []string          `json:"ips,omitempty"`
+		LastAnnounce time.Time         `json:"last_announce"`
+		Port         int               `json:"port,omitempty"`
+		Healthy      bool              `json:"healthy"`
+		Version      string            `json:"version,omitempty"`
+		Region       string            `json:"region,omitempty"`
+		Labels       map[string]string `json:"labels,omitempty"`
+		LastError    string            `json:"last_error,omitempty"`
+		PublicKey    string            `json:"public_key,omitempty"`
+		Signature    string            `json:"signature,omitempty"`
+		Aliases      []string          `json:"aliases,omitempty"`
+
+		Extra map[string]json.RawMessage `json:"-"`
+	}
+
+	// serverKey identifies a server on the list. Only one of its fields is
+	// set.
+	serverKey struct {
+		id   string
+		name string
+	}
+
+	// serverList is the versioned envelope in which we store the list.
+	serverList struct {
+		Version int      `json:"version"`
+		Servers []server `json:"servers"`
+	}
+
+	// ipChange describes the change of our IP caused by updating our own
+	// record. Old is empty when we weren't on the list before.
+	ipChange struct {
+		Old string
+		New string
+	}
+
+	// announceResult is the machine-readable result of a successful announce.
+	announceResult struct {
+		Skylink string   `json:"skylink"`
+		Servers []server `json:"servers"`
+	}
+
+	// options are the command line flags which select what run does. Flags
+	// which only override the config are applied to the config instead.
+	// * output is the output format, either outputText or outputJSON.
+	// * check, raw, list, stale, dryRun, diffOnly, observe, evict, importPath
+	// and deregister select an operation to run instead of announcing, see
+	// the flags of the same names.
+	// * hex makes raw print a hex dump.
+	// * watch is the interval on which we print the changes of the lists
+	// instead of announcing. It's zero if we don't watch the lists.
+	// * readBackup makes list and stale read the backup of a list if reading
+	// the list fails.
+	// * confirm confirms destructive operations.
+	// * noSpread skips the random delay before the first announce.
+	// * verifySkylink verifies the skylink after each announce.
+	// * daemon keeps re-announcing until we receive a shutdown signal.
+	// * metricsSnapshot is where we write a snapshot of the metrics on exit,
+	// "-" for stdout. It's empty if we don't write one.
+	options struct {
+		output          string
+		check           bool
+		raw             bool
+		hex             bool
+		watch           time.Duration
+		readBackup      bool
+		list            bool
+		stale           bool
+		dryRun          bool
+		diffOnly        bool
+		observe         bool
+		evict           string
+		importPath      string
+		deregister      bool
+		confirm         bool
+		noSpread        bool
+		verifySkylink   bool
+		daemon          bool
+		metricsSnapshot string
 	}
 )
 
-// getServerList loads the server list from SkyDB.
-func getServerList(db *skydb.SkyDB, tweak [32]byte) ([]server, uint64, error) {
-	b, rev, err := db.Read(tweak)
-	if err != nil && strings.Contains(err.Error(), "skydb entry not found") {
+// newLogger returns a logger which writes messages of the given level and above
+// to w, either as plain text or as JSON.
+func newLogger(w io.Writer, level slog.Level, asJSON bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if asJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// withContext runs fn and waits for it to return or for the context to be done,
+// whichever comes first. SkyDB and the skyd client don't support contexts, so a
+// call we give up on keeps running in the background and its results are
+// discarded. Since fn runs in a goroutine of its own, runAttempt can't recover
+// its panics, so we recover them here and return them as errors.
+func withContext(ctx context.Context, fn func()) error {
+	// The channel is buffered, so the goroutine doesn't leak when we give up
+	// on it.
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("call panicked", "panic", r, "stack", string(debug.Stack()))
+				done <- errors.New(fmt.Sprintf("call panicked: %v", r))
+			}
+		}()
+		fn()
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readRawList reads the stored bytes of the list from SkyDB, without trying to
+// unmarshal them. If the list doesn't exist, the returned error contains
+// skydb.ErrNotFound. Errors name the server reading the list, so they can be
+// told apart in the logs of several servers.
+func readRawList(ctx context.Context, db skyDB, tweak [32]byte, ownName string) ([]byte, uint64, error) {
+	var b []byte
+	var rev uint64
+	var err error
+	msg := "failed to read list " + tweakID(tweak) + " from skydb for " + ownName
+	ctxErr := withContext(ctx, func() {
+		b, rev, err = db.Read(tweak)
+	})
+	if ctxErr != nil {
+		return nil, 0, errors.AddContext(ctxErr, msg)
+	}
+	if isAuthError(err) {
+		return nil, 0, errors.Extend(errors.AddContext(err, msg), ErrAuthFailed)
+	}
+	if err != nil {
+		return nil, 0, errors.AddContext(err, msg)
+	}
+	return b, rev, nil
+}
+
+// tweakID returns a short identifier of the list with the given tweak for use
+// in error messages and logs.
+func tweakID(tweak [32]byte) string {
+	return hex.EncodeToString(tweak[:4])
+}
+
+// getServerList loads the server list from SkyDB on behalf of the server with
+// the given name. It drops the records whose signatures don't verify against
+// the given trusted keys, see dropUnverified.
+func getServerList(ctx context.Context, db skyDB, tweak [32]byte, ownName string, trusted map[string]crypto.PublicKey) ([]server, uint64, error) {
+	b, rev, err := readRawList(ctx, db, tweak, ownName)
+	if errors.Contains(err, skydb.ErrNotFound) {
 		return []server{}, 0, nil
 	}
 	if err != nil {
-		return nil, 0, errors.AddContext(err, "failed to read from skydb")
+		return nil, 0, err
 	}
-	var servers []server
-	err = json.Unmarshal(b, &servers)
+	servers, err := unmarshalServerList(b)
 	if err != nil {
-		return nil, 0, errors.AddContext(err, "failed to unmarshal server list")
+		return nil, 0, errors.AddContext(err, "failed to unmarshal server list "+tweakID(tweak)+" for "+ownName)
 	}
-	fmt.Printf("got %d: %v\n", rev, servers)
+	// We drop forged records before deduplicating, so they can't shadow the
+	// genuine ones.
+	servers = dedupServers(dropUnverified(servers, trusted))
+	logger.Debug("got server list", "revision", rev, "servers", servers)
 	return servers, rev, nil
 }
 
-// putServerList stores the server list in SkyDB.
-func putServerList(db *skydb.SkyDB, list []server, tweak [32]byte, rev uint64) error {
-	data, err := json.Marshal(list)
+// unmarshalServerList decodes a stored list. It supports both the current,
+// versioned format and the legacy bare array, either of them compressed or
+// not. Legacy lists get upgraded the next time we write them. A null list and
+// an envelope without servers are rejected, since they would otherwise decode
+// into an empty list, which our next write would store for everyone.
+func unmarshalServerList(b []byte) ([]server, error) {
+	b, err := decompressList(b)
 	if err != nil {
-		return errors.AddContext(err, "failed to marshal server list")
+		return nil, err
 	}
-	err = db.Write(data, tweak, rev)
-	if err != nil {
-		return errors.AddContext(err, "failed to write to skydb")
+	if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil, errors.New("stored list is null")
 	}
-	fmt.Printf("put %d: %v\n", rev, list)
-	return nil
+	// The pointer tells a missing or null servers field apart from an empty
+	// list.
+	var list struct {
+		Version int       `json:"version"`
+		Servers *[]server `json:"servers"`
+	}
+	err = json.Unmarshal(b, &list)
+	if err == nil {
+		if list.Version > listVersion {
+			return nil, errors.New(fmt.Sprintf("unsupported list version %d, the latest supported one is %d", list.Version, listVersion))
+		}
+		if list.Servers == nil {
+			return nil, errors.New("stored list has no servers")
+		}
+		return *list.Servers, nil
+	}
+	var servers []server
+	legacyErr := json.Unmarshal(b, &servers)
+	if legacyErr != nil {
+		return nil, errors.Compose(err, legacyErr)
+	}
+	logger.Debug("read a legacy server list, it will be upgraded on the next write")
+	return servers, nil
 }
 
-// updateOwnRecord adds our information to the list, removing the existing entry
-// if it exists. If the server has multiple IP addresses, the address in the
-// list might change between executions.
-func updateOwnRecord(list []server, ownName string) ([]server, error) {
-	ip, err := getOwnIP()
-	if err != nil {
-		// The IP is not critical to the operation of the tool, so we will just
-		// skip setting it.
-		fmt.Println(errors.AddContext(err, "failed to get own ip").Error())
-		ip = ""
+// key returns the identity of the server, which is its node ID if it has one
+// and its name otherwise.
+func (s server) key() serverKey {
+	if s.ID != "" {
+		return serverKey{id: s.ID}
 	}
-	for i := range list {
-		if list[i].Name == ownName {
-			if ip != "" {
-				list[i].IP = ip
-			}
-			list[i].LastAnnounce = time.Now()
-			return list, nil
+	return serverKey{name: s.Name}
+}
+
+// diffLists compares two versions of a list. It returns the servers which are
+// only on the new list, the new versions of the servers which changed, and the
+// servers which are only on the old list.
+func diffLists(old, new []server) (added, updated, removed []server) {
+	oldByKey := make(map[serverKey]server, len(old))
+	for _, s := range old {
+		oldByKey[s.key()] = s
+	}
+	newKeys := make(map[serverKey]struct{}, len(new))
+	for _, s := range new {
+		newKeys[s.key()] = struct{}{}
+		o, exists := oldByKey[s.key()]
+		if !exists {
+			added = append(added, s)
+			continue
+		}
+		if !sameServer(o, s) {
+			updated = append(updated, s)
 		}
 	}
-	self := server{
-		Name:         ownName,
-		IP:           ip,
-		LastAnnounce: time.Now(),
+	for _, s := range old {
+		if _, exists := newKeys[s.key()]; !exists {
+			removed = append(removed, s)
+		}
 	}
-	return append(list, self), nil
+	return added, updated, removed
 }
 
-// removeOutdatedEntries prunes all entries in the list that haven't been
-// updated in 7 days.
-func removeOutdatedEntries(list []server) []server {
-	cutoff := time.Now().AddDate(0, 0, -7)
-	var updatedList []server
+// sameServer checks whether the two records are identical once stored.
+func sameServer(a, b server) bool {
+	aBytes, aErr := json.Marshal(a)
+	bBytes, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aBytes, bBytes)
+}
+
+// dedupServers collapses all entries of the same server into a single one,
+// keeping the one with the most recent announce. Servers are identified by
+// their node ID if they have one and by their name otherwise. The order of the
+// list is otherwise preserved.
+func dedupServers(list []server) []server {
+	idx := make(map[serverKey]int, len(list))
+	var deduped []server
 	for _, s := range list {
-		if s.LastAnnounce.After(cutoff) {
-			updatedList = append(updatedList, s)
+		k := s.key()
+		i, exists := idx[k]
+		if !exists {
+			idx[k] = len(deduped)
+			deduped = append(deduped, s)
+			continue
 		}
+		if s.LastAnnounce.After(deduped[i].LastAnnounce) {
+			deduped[i] = s
+		}
+	}
+	if removed := len(list) - len(deduped); removed > 0 {
+		logger.Info("removed duplicate entries from the server list", "removed", removed)
 	}
-	return updatedList
+	return deduped
 }
 
-// getConfig reads all the configuration data for the service. This data comes
-// mostly from environment variables.
-func getConfig() (config, error) {
-	cfg := config{}
-
-	ownName := os.Getenv("SERVER_DOMAIN")
-	if ownName == "" {
-		ownName = os.Getenv("PORTAL_DOMAIN")
+// marshalServerList encodes the list in the format in which we store it. The
+// servers are sorted by name and IP, so the same set of servers always results
+// in the same bytes, regardless of the order of the given list. If pretty is
+// set, the JSON is indented, which makes it easier to read for humans at the
+// cost of a considerably larger list.
+func marshalServerList(list []server, pretty bool) ([]byte, error) {
+	sorted := make([]server, len(list))
+	copy(sorted, list)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].IP < sorted[j].IP
+	})
+	sl := serverList{
+		Version: listVersion,
+		Servers: sorted,
 	}
-	if ownName == "" {
-		return config{}, errors.New("failed to get own name. is SERVER_DOMAIN or PORTAL_DOMAIN env var defined?")
+	if pretty {
+		return json.MarshalIndent(sl, "", "  ")
 	}
-	cfg.OwnName = strings.TrimPrefix(strings.TrimPrefix(ownName, "http://"), "https://")
+	return json.Marshal(sl)
+}
 
-	entropyStr := os.Getenv("SERVERLIST_ENTROPY")
-	if entropyStr == "" {
-		return config{}, errors.New("failed to get entropy. is SERVERLIST_ENTROPY env var defined?")
+// putServerList stores the server list in SkyDB, encoded as configured by
+// cfg.Pretty and cfg.Compress. It refuses to write lists with more than
+// cfg.MaxServers servers or more than cfg.MaxBytes bytes.
+func putServerList(ctx context.Context, db skyDB, list []server, tweak [32]byte, rev uint64, cfg config) error {
+	if len(list) > cfg.MaxServers {
+		return errors.AddContext(ErrTooManyServers, fmt.Sprintf("refusing to write %d servers, the maximum is %d", len(list), cfg.MaxServers))
 	}
-	bytes, err := hex.DecodeString(entropyStr)
+	data, err := encodeList(list, cfg)
 	if err != nil {
-		return config{}, errors.AddContext(err, "invalid SERVERLIST_ENTROPY value")
+		return err
 	}
-	copy(cfg.Entropy[:], bytes)
-
-	tweakStr := os.Getenv("SERVERLIST_TWEAK")
-	if ownName == "" {
-		return config{}, errors.New("failed to get tweak. is SERVERLIST_TWEAK env var defined?")
+	// skyd's error for an oversized upload doesn't tell us much, so we
+	// check the size ourselves.
+	err = checkSize(data, cfg)
+	if err != nil {
+		return errors.AddContext(err, "refusing to write server list")
+	}
+	// Writing a list that we can't read back would break every server
+	// using it, so we make sure that never happens.
+	err = verifyRoundTrip(data, list)
+	if err != nil {
+		return errors.AddContext(err, "refusing to write server list")
+	}
+	msg := "failed to write list " + tweakID(tweak) + " to skydb for " + cfg.OwnName
+	ctxErr := withContext(ctx, func() {
+		err = db.Write(data, tweak, rev)
+	})
+	if ctxErr != nil {
+		return errors.AddContext(ctxErr, msg)
+	}
+	if isRevisionConflict(err) {
+		return errors.Extend(errors.AddContext(err, msg), ErrRevisionConflict)
+	}
+	if isAuthError(err) {
+		return errors.Extend(errors.AddContext(err, msg), ErrAuthFailed)
 	}
-	bytes, err = hex.DecodeString(tweakStr)
 	if err != nil {
-		return config{}, errors.AddContext(err, "invalid SERVERLIST_TWEAK value")
+		return errors.AddContext(err, msg)
 	}
-	copy(cfg.Tweak[:], bytes)
+	logger.Debug("put server list", "revision", rev, "servers", list)
+	return nil
+}
 
-	cfg.SkydAddress = os.Getenv("SERVERLIST_SKYD")
-	if cfg.SkydAddress == "" {
-		cfg.SkydAddress = "localhost:9980"
+// checkShrink makes sure that the list we're about to write isn't empty and
+// that it doesn't drop more than cfg.MaxShrink of the servers on the list we
+// read. Servers which pruneServers drops are expected to go, so we only count
+// the unexpected drops, against the read list after pruning. The same goes for
+// our own record, which we replace, and the records of the aliases we retired,
+// see dropRetiredAliases. Legitimate updates rarely drop that many servers, so
+// a list that does most likely results from a bug or a wrong clock and writing
+// it would wipe the list for everyone.
+func checkShrink(read, next []server, cfg config) error {
+	if len(next) == 0 {
+		return errors.AddContext(ErrListShrunk, "refusing to write an empty list")
+	}
+	read = pruneServers(read, cfg)
+	own := ownRecordIndex(read, cfg.OwnName, cfg.NodeID)
+	var retired map[string]bool
+	if own >= 0 {
+		retired = retiredAliases(read[own], server{ID: cfg.NodeID, Name: cfg.OwnName, Aliases: cfg.AliasNames})
+	}
+	keys := make(map[serverKey]struct{}, len(next))
+	for _, s := range next {
+		keys[s.key()] = struct{}{}
+	}
+	counted, dropped := 0, 0
+	for i, s := range read {
+		if i == own || (s.ID == "" && retired[s.Name]) {
+			continue
+		}
+		counted++
+		if _, exists := keys[s.key()]; !exists {
+			dropped++
+		}
+	}
+	if counted == 0 {
+		return nil
 	}
+	if float64(dropped)/float64(counted) > cfg.MaxShrink {
+		return errors.AddContext(ErrListShrunk, fmt.Sprintf("refusing to drop %d of the %d unpruned servers on the list, the maximum fraction is %v", dropped, counted, cfg.MaxShrink))
+	}
+	return nil
+}
 
-	cfg.SkydApiPassword = os.Getenv("SIA_API_PASSWORD")
-	if cfg.SkydApiPassword == "" {
-		return config{}, errors.New("failed to get api password. is SIA_API_PASSWORD env var defined?")
+// verifyRoundTrip checks that the given marshalled list unmarshals into the
+// same servers as the given list, as far as their identities go.
+func verifyRoundTrip(data []byte, list []server) error {
+	readBack, err := unmarshalServerList(data)
+	if err != nil {
+		return errors.AddContext(err, "marshalled list can't be unmarshalled")
+	}
+	if len(readBack) != len(list) {
+		return errors.New(fmt.Sprintf("marshalled list contains %d servers instead of %d", len(readBack), len(list)))
+	}
+	// marshalServerList sorts the servers, so we compare them as sets.
+	want := make(map[string]int, len(list))
+	for _, s := range list {
+		want[s.ID+"/"+s.Name]++
+	}
+	for _, s := range readBack {
+		key := s.ID + "/" + s.Name
+		if want[key] == 0 {
+			return errors.New(fmt.Sprintf("marshalled list contains unexpected server '%s'", s.Name))
+		}
+		want[key]--
 	}
+	return nil
+}
 
-	return cfg, nil
+// isRevisionConflict checks whether the given skydb write error was caused by
+// another server writing the same or a higher revision before us. Skyd reports
+// these over its HTTP API, so we have to match them by their text.
+func isRevisionConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range []error{modules.ErrLowerRevNum, modules.ErrSameRevNum, modules.ErrInsufficientWork} {
+		if strings.Contains(err.Error(), e.Error()) {
+			return true
+		}
+	}
+	return false
 }
 
-// getOwnIP uses an external service in order to discover our external IP.
-func getOwnIP() (string, error) {
-	resp, err := http.Get("https://api.ipify.org")
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return "", errors.AddContext(err, "failed to query api.ipify.org")
+// updateOwnRecord adds our information to the list, removing the existing entry
+// if it exists. If the server has multiple IP addresses, the address in the
+// list might change between executions. The getIP function is used in order to
+// discover our external IP and skyd is queried for our health and version.
+// When cfg.ResolveName is set, we announce all IPs our name resolves to
+// instead and only fall back to getIP when the resolution fails. Since a
+// changed IP often explains connectivity issues, we log a warning when it
+// differs from the one on the list and return both of them. Failed skyd
+// queries end up in the record's LastError, which a clean announce clears.
+// Each of our aliases gets a copy of our record, see upsertAliases, and the
+// records of the aliases we no longer announce are removed.
+func updateOwnRecord(ctx context.Context, list []server, cfg config, getIP func(context.Context) (string, error), skyd skydClient) ([]server, ipChange, error) {
+	var ips []string
+	var err error
+	// An explicitly configured IP takes precedence over the resolved ones.
+	if cfg.ResolveName && cfg.IP == "" {
+		ips, err = resolveIPs(ctx, cfg.OwnName)
+		if err != nil {
+			logger.Warn("failed to resolve own name, discovering own ip instead", "name", cfg.OwnName, "error", err)
+		}
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	var ip string
+	if len(ips) > 0 {
+		// We keep announcing a single IP for the benefit of older consumers
+		// of the list.
+		ip = ips[0]
+	} else {
+		ip, err = getIP(ctx)
+		if err != nil {
+			// The IP is not critical to the operation of the tool, so we will
+			// just skip setting it.
+			logger.Warn("failed to get own ip", "error", err)
+			ip = ""
+		}
+	}
+	// We publish the problems we ran into, so they're visible to everyone
+	// reading the list.
+	var problems error
+	healthy, err := isHealthy(ctx, skyd)
 	if err != nil {
-		return "", errors.AddContext(err, "failed to read api.ipify.org response")
+		// Failing to reach skyd means that we're not healthy but it shouldn't
+		// prevent us from announcing.
+		logger.Warn("failed to check skyd health", "error", err)
+		problems = errors.Compose(problems, err)
 	}
-	ip := string(bodyBytes)
-	// This regex only detects IPv4. We might need to expand it in the future,
-	// so it supports IPv6 as well.
-	match, err := regexp.MatchString("^\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}$", ip)
-	if err != nil || !match {
-		msg := fmt.Sprintf("invalid ip received '%s'", ip)
-		return "", errors.AddContext(err, msg)
+	version, err := skydVersion(ctx, skyd)
+	if err != nil {
+		// The version is informational, so we just leave it empty.
+		logger.Warn("failed to get skyd version", "error", err)
+		problems = errors.Compose(problems, err)
+	}
+	lastError := ""
+	if problems != nil {
+		lastError = problems.Error()
+	}
+	var self server
+	var change ipChange
+	var retired map[string]bool
+	if i := ownRecordIndex(list, cfg.OwnName, cfg.NodeID); i >= 0 {
+		prev := list[i]
+		change = ipChange{Old: list[i].IP, New: list[i].IP}
+		if ip != "" {
+			change.New = ip
+			list[i].IP = ip
+		}
+		if change.changed() {
+			logger.Warn("own ip changed", "name", cfg.OwnName, "old_ip", change.Old, "new_ip", change.New)
+		}
+		// When we have a node ID, this also renames our record.
+		list[i].IPs = ips
+		list[i].ID = cfg.NodeID
+		list[i].Name = cfg.OwnName
+		list[i].Port = cfg.OwnPort
+		list[i].Healthy = healthy
+		list[i].Version = version
+		list[i].Region = cfg.Region
+		if cfg.Labels != nil {
+			list[i].Labels = cfg.Labels
+		}
+		list[i].LastError = lastError
+		list[i].Aliases = cfg.AliasNames
+		// We fully own our record, so we drop any fields we don't know
+		// about.
+		list[i].Extra = nil
+		// The old signature doesn't cover the new announce time, see
+		// signOwnRecords.
+		list[i].PublicKey = ""
+		list[i].Signature = ""
+		list[i].LastAnnounce = clock()
+		self = list[i]
+		retired = retiredAliases(prev, self)
+	} else {
+		self = server{
+			ID:           cfg.NodeID,
+			Name:         cfg.OwnName,
+			IP:           ip,
+			IPs:          ips,
+			LastAnnounce: clock(),
+			Port:         cfg.OwnPort,
+			Healthy:      healthy,
+			Version:      version,
+			Region:       cfg.Region,
+			Labels:       cfg.Labels,
+			LastError:    lastError,
+			Aliases:      cfg.AliasNames,
+		}
+		list = append(list, self)
+		change = ipChange{New: ip}
 	}
-	return ip, nil
+	list = dropRetiredAliases(list, retired)
+	return upsertAliases(list, self, cfg.AliasNames), change, nil
 }
 
-// checkSuccess fetches the list of servers and ensures that this server's
-// record was updated within the last 5 minutes.
-func checkSuccess(db *skydb.SkyDB, tweak [32]byte, ownName string) bool {
-	list, _, err := getServerList(db, tweak)
-	if err != nil {
-		return false
+// upsertAliases adds a copy of our own record to the list for each of the
+// given alias names, replacing the existing records of the aliases. The copies
+// don't carry our node ID, so they're identified by their names.
+func upsertAliases(list []server, self server, aliases []string) []server {
+	for _, alias := range aliases {
+		record := self
+		record.ID = ""
+		record.Name = alias
+		record.Aliases = nil
+		found := false
+		for i, s := range list {
+			if s.ID == "" && s.Name == alias {
+				list[i] = record
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, record)
+		}
+	}
+	return list
+}
+
+// isServer checks whether s is the record of the server with the given name
+// and node ID. Servers with a node ID are identified by it, all others by
+// their name.
+func isServer(s server, name, id string) bool {
+	if id != "" {
+		return s.ID == id
+	}
+	return s.Name == name
+}
+
+// ownRecordIndex returns the index of the record of the server with the given
+// name and node ID in the list, or -1 if it's not on the list. A record with
+// our name but without an ID counts as ours, since we wrote it before we got
+// our ID.
+func ownRecordIndex(list []server, name, id string) int {
+	for i := range list {
+		if isServer(list[i], name, id) {
+			return i
+		}
+	}
+	if id == "" {
+		return -1
+	}
+	for i := range list {
+		if list[i].ID == "" && list[i].Name == name {
+			return i
+		}
 	}
+	return -1
+}
+
+// ensureOwnRecord returns an error if the list doesn't contain a server with
+// our name. This can only happen due to a bug in the code which updates the
+// list.
+func ensureOwnRecord(list []server, ownName, nodeID string) error {
 	for _, s := range list {
-		if s.Name == ownName {
-			return s.LastAnnounce.After(time.Now().Add(-5 * time.Minute))
+		if isServer(s, ownName, nodeID) {
+			return nil
 		}
 	}
-	return false
+	return errors.New(fmt.Sprintf("the updated list doesn't contain our own record '%s'", ownName))
 }
 
-func main() {
-	err := godotenv.Load(os.Args[1])
+// changed reports whether our IP changed. Neither learning our IP for the
+// first time nor failing to discover it counts as a change.
+func (c ipChange) changed() bool {
+	return c.Old != "" && c.Old != c.New
+}
+
+// skydIP returns the public IP skyd's gateway announces to its peers. It
+// fails when the gateway only knows a non-public address.
+func skydIP(ctx context.Context, skyd skydClient) (string, error) {
+	var gwg siaapi.GatewayGET
+	var err error
+	ctxErr := withContext(ctx, func() {
+		gwg, err = skyd.GatewayGet()
+	})
+	if ctxErr != nil {
+		return "", errors.AddContext(ctxErr, "failed to query skyd's gateway")
+	}
 	if err != nil {
-		log.Fatal(errors.AddContext(err, "failed to load .env"))
+		return "", errors.AddContext(err, "failed to query skyd's gateway")
+	}
+	ip := net.ParseIP(gwg.NetAddress.Host())
+	if ip == nil {
+		return "", errors.New(fmt.Sprintf("skyd's gateway address '%s' doesn't contain an ip", gwg.NetAddress))
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return "", errors.New(fmt.Sprintf("skyd's gateway address '%s' is not public", gwg.NetAddress))
+	}
+	return ip.String(), nil
+}
+
+// isHealthy checks whether the local skyd is fully ready.
+func isHealthy(ctx context.Context, skyd skydClient) (bool, error) {
+	var dr api.DaemonReady
+	var err error
+	ctxErr := withContext(ctx, func() {
+		dr, err = skyd.DaemonReadyGet()
+	})
+	if ctxErr != nil {
+		return false, errors.AddContext(ctxErr, "failed to query skyd's readiness")
 	}
-	cfg, err := getConfig()
 	if err != nil {
-		log.Fatal(errors.AddContext(err, "failed to read config"))
+		return false, errors.AddContext(err, "failed to query skyd's readiness")
 	}
-	sk, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
-	opts := client.Options{
-		Address:   cfg.SkydAddress,
-		Password:  cfg.SkydApiPassword,
-		UserAgent: "Sia-Agent",
+	return dr.Ready, nil
+}
+
+// skydVersion returns the version of the local skyd.
+func skydVersion(ctx context.Context, skyd skydClient) (string, error) {
+	var dvg api.DaemonVersionGet
+	var err error
+	ctxErr := withContext(ctx, func() {
+		dvg, err = skyd.DaemonVersionGet()
+	})
+	if ctxErr != nil {
+		return "", errors.AddContext(ctxErr, "failed to query skyd's version")
 	}
-	db, err := skydb.New(sk, pk, opts)
 	if err != nil {
-		log.Fatal(errors.AddContext(err, "failed to get skydb instance"))
+		return "", errors.AddContext(err, "failed to query skyd's version")
 	}
+	return dvg.Version, nil
+}
 
-	// get the latest server list, update it and save it. then verify that we're
-	// in the list with a recent record. if that's not true sleep for a while
-	// and try again.
-	isRetryRun := false
-	for {
-		if isRetryRun {
-			// sleep between 0 and 3 minutes to allow other servers to finish their
-			// updates without running into a series of races
-			rand.Seed(int64(fastrand.Uint64n(math.MaxInt64)))
-			sleepDur := time.Duration(rand.Intn(3*60)) * time.Second
-			fmt.Printf("update was unsuccessful. sleeping for %d seconds.\n", sleepDur/time.Second)
-			time.Sleep(sleepDur)
-		}
-		list, rev, err := getServerList(db, cfg.Tweak)
-		if err != nil {
-			fmt.Println(errors.AddContext(err, "failed to get server list"))
-			isRetryRun = true
-			continue
+// pruneServers prunes the list according to the configured policy, unless
+// pruning is disabled, and, if configured, trims it down to the maximum number
+// of servers.
+func pruneServers(list []server, cfg config) []server {
+	if cfg.Prune {
+		list = prunePolicy(cfg).Prune(list, clock())
+	}
+	if cfg.TrimServers {
+		list = trimServers(list, cfg.MaxServers)
+	}
+	return list
+}
+
+// trimServers drops the servers with the oldest announces from the list until
+// it has at most maxServers servers. It preserves the order of the remaining
+// servers.
+func trimServers(list []server, maxServers int) []server {
+	if len(list) <= maxServers {
+		return list
+	}
+	byAge := make([]server, len(list))
+	copy(byAge, list)
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return byAge[i].LastAnnounce.After(byAge[j].LastAnnounce)
+	})
+	// We keep all servers newer than the cutoff and as many of the ones
+	// announced exactly at the cutoff as fit.
+	cutoff := byAge[maxServers-1].LastAnnounce
+	ties := 0
+	for _, s := range byAge[:maxServers] {
+		if s.LastAnnounce.Equal(cutoff) {
+			ties++
+		}
+	}
+	var trimmed []server
+	for _, s := range list {
+		if s.LastAnnounce.After(cutoff) {
+			trimmed = append(trimmed, s)
+		} else if s.LastAnnounce.Equal(cutoff) && ties > 0 {
+			trimmed = append(trimmed, s)
+			ties--
 		}
-		updatedList, err := updateOwnRecord(list, cfg.OwnName)
+	}
+	return trimmed
+}
+
+// parseTweaks parses the comma-separated, hex encoded tweaks in the value of
+// the env var with the given name.
+func parseTweaks(name, value string) ([][32]byte, error) {
+	var tweaks [][32]byte
+	for _, t := range strings.Split(value, ",") {
+		bytes, err := hex.DecodeString(strings.TrimSpace(t))
 		if err != nil {
-			fmt.Println(errors.AddContext(err, "failed to update list"))
-			isRetryRun = true
-			continue
+			return nil, errors.Extend(errors.AddContext(err, "invalid "+name+" value"), ErrInvalidTweak)
+		}
+		var tweak [32]byte
+		if len(bytes) != len(tweak) {
+			return nil, errors.AddContext(ErrInvalidTweak, fmt.Sprintf("invalid %s value, expected %d bytes, got %d", name, len(tweak), len(bytes)))
+		}
+		copy(tweak[:], bytes)
+		tweaks = append(tweaks, tweak)
+	}
+	return tweaks, nil
+}
+
+// getConfig reads all the configuration data for the service. This data comes
+// mostly from environment variables. Misconfigurations of the server's identity
+// and credentials result in errors that contain one of the ErrMissing* or
+// ErrInvalid* errors, which callers can detect with errors.Contains.
+func getConfig() (config, error) {
+	return readConfig(false)
+}
+
+// getReadOnlyConfig is getConfig for the modes which never announce, i.e.
+// -observe and -skylink. They need neither our name nor the API password.
+func getReadOnlyConfig() (config, error) {
+	return readConfig(true)
+}
+
+// readConfig implements getConfig and getReadOnlyConfig.
+func readConfig(readOnly bool) (config, error) {
+	cfg := config{}
+
+	// SKYNET_SERVER_API holds the comma-separated list of our names. We
+	// fall back to the single names of older deployments.
+	var ownName string
+	for _, name := range ownNameVars {
+		ownName = os.Getenv(name)
+		if ownName != "" {
+			break
 		}
-		cleanList := removeOutdatedEntries(updatedList)
-		err = putServerList(db, cleanList, cfg.Tweak, rev+1)
+	}
+	// A name template replaces the name, so we only need one of them.
+	nameTemplate := os.Getenv("SERVERLIST_NAME_TEMPLATE")
+	if ownName == "" && nameTemplate == "" && !readOnly {
+		return config{}, errors.AddContext(ErrMissingOwnName, "failed to get own name. is SKYNET_SERVER_API, SERVER_DOMAIN or PORTAL_DOMAIN env var defined?")
+	}
+	var err error
+	// namePort is the non-default port in the server name, if any.
+	var namePort int
+	if ownName != "" {
+		// The first name is our own, any further ones are aliases.
+		names := strings.Split(ownName, ",")
+		cfg.OwnName, namePort, err = parseOwnName(strings.TrimSpace(names[0]))
 		if err != nil {
-			fmt.Println(errors.AddContext(err, "failed to update server list"))
-			isRetryRun = true
-			continue
+			return config{}, errors.Extend(err, ErrInvalidOwnName)
+		}
+		seen := map[string]bool{cfg.OwnName: true}
+		for _, n := range names[1:] {
+			alias, aliasPort, err := parseOwnName(strings.TrimSpace(n))
+			if err != nil {
+				return config{}, errors.Extend(err, ErrInvalidOwnName)
+			}
+			// Aliases share our record, including its port.
+			if aliasPort != 0 && aliasPort != namePort {
+				return config{}, errors.AddContext(ErrInvalidOwnName, fmt.Sprintf("server name '%s' has a different port than '%s'", n, names[0]))
+			}
+			if seen[alias] {
+				return config{}, errors.AddContext(ErrInvalidOwnName, fmt.Sprintf("duplicate server name '%s'", alias))
+			}
+			seen[alias] = true
+			cfg.AliasNames = append(cfg.AliasNames, alias)
+		}
+	}
+
+	if nodeID := os.Getenv("SERVERLIST_NODE_ID"); nodeID != "" {
+		if !uuidRegex.MatchString(nodeID) {
+			return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_NODE_ID value '%s', it must be a UUID", nodeID))
+		}
+		cfg.NodeID = strings.ToLower(nodeID)
+	}
+
+	if nodeKeyStr := os.Getenv("SERVERLIST_NODE_KEY"); nodeKeyStr != "" {
+		cfg.NodeKey, err = hex.DecodeString(nodeKeyStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_NODE_KEY value")
+		}
+		if len(cfg.NodeKey) != crypto.EntropySize {
+			return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_NODE_KEY value, expected %d bytes, got %d", crypto.EntropySize, len(cfg.NodeKey)))
+		}
+	}
+
+	if trustedStr := os.Getenv("SERVERLIST_TRUSTED_KEYS"); trustedStr != "" {
+		cfg.TrustedKeys, err = parseTrustedKeys(trustedStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_TRUSTED_KEYS value")
 		}
-		// We want to sleep here for a bit in order to give the system time to
-		// stabilize, otherwise we can run into a race where two machines write
-		// different data for the same revision and both get positive responses
-		// but only one of them gets selected as winner and gets their data
-		// persisted.
-		time.Sleep(3 * time.Second)
-		if !checkSuccess(db, cfg.Tweak, cfg.OwnName) {
-			fmt.Println("success check failed")
-			isRetryRun = true
+	}
+	// Readers drop records which aren't signed by their trusted key, so we'd
+	// drop our own records if we didn't sign them with it.
+	for _, name := range append([]string{cfg.OwnName}, cfg.AliasNames...) {
+		trusted, pinned := cfg.TrustedKeys[name]
+		if !pinned {
 			continue
 		}
-		break
+		if len(cfg.NodeKey) == 0 {
+			return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_TRUSTED_KEYS value, %s has a trusted key but SERVERLIST_NODE_KEY is not set", name))
+		}
+		var seed [crypto.EntropySize]byte
+		copy(seed[:], cfg.NodeKey)
+		if _, pk := crypto.GenerateKeyPairDeterministic(seed); pk != trusted {
+			return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_TRUSTED_KEYS value, the trusted key of %s doesn't match SERVERLIST_NODE_KEY", name))
+		}
+	}
+
+	if portStr := os.Getenv("SKYNET_SERVER_PORT"); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return config{}, errors.Extend(errors.AddContext(err, "invalid SKYNET_SERVER_PORT value"), ErrInvalidPort)
+		}
+		if port < 1 || port > 65535 {
+			return config{}, errors.AddContext(ErrInvalidPort, "invalid SKYNET_SERVER_PORT value, it must be between 1 and 65535")
+		}
+		cfg.OwnPort = port
+	}
+
+	cfg.Region = strings.TrimSpace(os.Getenv("SERVERLIST_REGION"))
+	if allowedStr := os.Getenv("SERVERLIST_REGIONS_ALLOWED"); allowedStr != "" && cfg.Region != "" {
+		allowed := false
+		for _, r := range strings.Split(allowedStr, ",") {
+			if strings.TrimSpace(r) == cfg.Region {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return config{}, errors.AddContext(ErrInvalidRegion, fmt.Sprintf("invalid SERVERLIST_REGION value '%s', it must be one of %s", cfg.Region, allowedStr))
+		}
 	}
 
-	// output the skylink. this serves as a confirmation of a successful run and
-	// as a handy way to get the skylink.
-	sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), cfg.Tweak)
-	fmt.Printf("skylink updated successfully: %s\n", sl.String())
+	// The name template can reference the region, so we render it once we
+	// have that.
+	if nameTemplate != "" {
+		data, err := newNameTemplateData(cfg.OwnName, cfg.Region)
+		if err != nil {
+			return config{}, err
+		}
+		cfg.OwnName, namePort, err = renderOwnName(nameTemplate, data)
+		if err != nil {
+			return config{}, errors.Extend(errors.AddContext(err, "invalid SERVERLIST_NAME_TEMPLATE value"), ErrInvalidOwnName)
+		}
+		// The rendered name replaces our name but not our aliases, so it
+		// must not be one of them.
+		for _, alias := range cfg.AliasNames {
+			if alias == cfg.OwnName {
+				return config{}, errors.AddContext(ErrInvalidOwnName, fmt.Sprintf("invalid SERVERLIST_NAME_TEMPLATE value, the rendered name '%s' is also an alias", alias))
+			}
+		}
+	}
+	// This only guards against servers announcing to the wrong list by
+	// mistake, anyone with the entropy can still write any name.
+	if allowedStr := os.Getenv("SERVERLIST_ALLOWED_NAMES"); allowedStr != "" && !readOnly {
+		allowed := make(map[string]bool)
+		for _, n := range strings.Split(allowedStr, ",") {
+			name, _, err := parseOwnName(strings.TrimSpace(n))
+			if err != nil {
+				return config{}, errors.AddContext(err, "invalid SERVERLIST_ALLOWED_NAMES value")
+			}
+			allowed[name] = true
+		}
+		for _, name := range append([]string{cfg.OwnName}, cfg.AliasNames...) {
+			if !allowed[name] {
+				return config{}, errors.AddContext(ErrInvalidOwnName, fmt.Sprintf("server name '%s' is not allowed to write to the list, it must be one of %s", name, allowedStr))
+			}
+		}
+	}
+
+	// We announce a non-default port in the name as the port of the server,
+	// so the name stays the same regardless of how it's written.
+	if namePort != 0 {
+		if cfg.OwnPort != 0 && cfg.OwnPort != namePort {
+			return config{}, errors.AddContext(ErrInvalidPort, fmt.Sprintf("the server name has port %d but SKYNET_SERVER_PORT is %d", namePort, cfg.OwnPort))
+		}
+		cfg.OwnPort = namePort
+	}
+
+	// An unset SERVERLIST_LABELS keeps the labels of our stored record, while
+	// an empty one clears them.
+	if labelsStr, ok := os.LookupEnv("SERVERLIST_LABELS"); ok {
+		cfg.Labels = make(map[string]string)
+		if labelsStr != "" {
+			cfg.Labels, err = parseLabels(labelsStr)
+			if err != nil {
+				return config{}, errors.AddContext(err, "invalid SERVERLIST_LABELS value")
+			}
+		}
+	}
+
+	entropyStr := os.Getenv("SERVERLIST_ENTROPY")
+	if entropyStr == "" {
+		return config{}, errors.AddContext(ErrMissingEntropy, "failed to get entropy. is SERVERLIST_ENTROPY env var defined?")
+	}
+	bytes, err := hex.DecodeString(entropyStr)
+	if err != nil {
+		return config{}, errors.Extend(errors.AddContext(err, "invalid SERVERLIST_ENTROPY value"), ErrInvalidEntropy)
+	}
+	if len(bytes) != len(cfg.Entropy) {
+		return config{}, errors.AddContext(ErrInvalidEntropy, fmt.Sprintf("invalid SERVERLIST_ENTROPY value, expected %d bytes, got %d", len(cfg.Entropy), len(bytes)))
+	}
+	copy(cfg.Entropy[:], bytes)
+
+	tweakStr := os.Getenv("SERVERLIST_TWEAK")
+	if tweakStr == "" {
+		return config{}, errors.AddContext(ErrMissingTweak, "failed to get tweak. is SERVERLIST_TWEAK env var defined?")
+	}
+	cfg.Tweaks, err = parseTweaks("SERVERLIST_TWEAK", tweakStr)
+	if err != nil {
+		return config{}, err
+	}
+
+	if backupStr := os.Getenv("SERVERLIST_BACKUP_TWEAK"); backupStr != "" {
+		cfg.BackupTweaks, err = parseTweaks("SERVERLIST_BACKUP_TWEAK", backupStr)
+		if err != nil {
+			return config{}, err
+		}
+		if len(cfg.BackupTweaks) != len(cfg.Tweaks) {
+			return config{}, errors.AddContext(ErrInvalidTweak, fmt.Sprintf("invalid SERVERLIST_BACKUP_TWEAK value, expected %d tweaks, one for each list, got %d", len(cfg.Tweaks), len(cfg.BackupTweaks)))
+		}
+		for i, backup := range cfg.BackupTweaks {
+			for _, tweak := range cfg.Tweaks {
+				if backup == tweak {
+					return config{}, errors.AddContext(ErrInvalidTweak, fmt.Sprintf("invalid SERVERLIST_BACKUP_TWEAK value, backup tweak %d is also a list tweak", i))
+				}
+			}
+		}
+	}
+
+	cfg.SkydAddress = os.Getenv("SERVERLIST_SKYD")
+	if cfg.SkydAddress == "" {
+		cfg.SkydAddress = "localhost:9980"
+	}
+
+	if tlsStr := os.Getenv("SERVERLIST_SKYD_TLS"); tlsStr != "" {
+		cfg.SkydTLS, err = strconv.ParseBool(tlsStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_SKYD_TLS value")
+		}
+	}
+	if caPath := os.Getenv("SERVERLIST_SKYD_CA"); caPath != "" {
+		if !cfg.SkydTLS {
+			return config{}, errors.New("SERVERLIST_SKYD_CA requires SERVERLIST_SKYD_TLS to be enabled")
+		}
+		cfg.SkydRootCAs, err = loadCAPool(caPath)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_SKYD_CA value")
+		}
+	}
+
+	cfg.SkydUserAgent = defaultUserAgent
+	if userAgent, ok := os.LookupEnv("SERVERLIST_USER_AGENT"); ok {
+		cfg.SkydUserAgent = strings.TrimSpace(userAgent)
+		if cfg.SkydUserAgent == "" {
+			return config{}, errors.New("invalid SERVERLIST_USER_AGENT value, it must not be empty")
+		}
+	}
+
+	cfg.Prune = true
+	if pruneStr := os.Getenv("SERVERLIST_PRUNE"); pruneStr != "" {
+		cfg.Prune, err = strconv.ParseBool(pruneStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_PRUNE value")
+		}
+	}
+
+	switch policy := os.Getenv("SERVERLIST_PRUNE_POLICY"); policy {
+	case "", prunePolicyTime:
+		cfg.PrunePolicy = prunePolicyTime
+	case prunePolicyCount:
+		cfg.PrunePolicy = prunePolicyCount
+	default:
+		return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_PRUNE_POLICY value '%s', it must be either %s or %s", policy, prunePolicyTime, prunePolicyCount))
+	}
+
+	cfg.PruneAfter = defaultPruneAfter
+	if pruneAfterStr := os.Getenv("SERVERLIST_PRUNE_AFTER"); pruneAfterStr != "" {
+		cfg.PruneAfter, err = time.ParseDuration(pruneAfterStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_PRUNE_AFTER value")
+		}
+		if cfg.PruneAfter <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_PRUNE_AFTER value, it must be positive")
+		}
+	}
+
+	cfg.StaleAfter = defaultStaleAfter(cfg.PruneAfter)
+	if staleAfterStr := os.Getenv("SERVERLIST_STALE_AFTER"); staleAfterStr != "" {
+		cfg.StaleAfter, err = time.ParseDuration(staleAfterStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_STALE_AFTER value")
+		}
+		if cfg.StaleAfter <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_STALE_AFTER value, it must be positive")
+		}
+	}
+
+	if ipv6Str := os.Getenv("SERVERLIST_IPV6"); ipv6Str != "" {
+		cfg.IPv6, err = strconv.ParseBool(ipv6Str)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_IPV6 value")
+		}
+	}
+
+	switch source := os.Getenv("SERVERLIST_TIME_SOURCE"); source {
+	case "", timeSourceLocal:
+		cfg.TimeSource = timeSourceLocal
+	case timeSourceSkyd:
+		cfg.TimeSource = timeSourceSkyd
+	default:
+		cfg.TimeSource, err = parseNTPServer(source)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_TIME_SOURCE value")
+		}
+	}
+
+	if fromSkydStr := os.Getenv("SERVERLIST_IP_FROM_SKYD"); fromSkydStr != "" {
+		cfg.IPFromSkyd, err = strconv.ParseBool(fromSkydStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_IP_FROM_SKYD value")
+		}
+	}
+
+	if resolveStr := os.Getenv("SERVERLIST_RESOLVE_NAME"); resolveStr != "" {
+		cfg.ResolveName, err = strconv.ParseBool(resolveStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_RESOLVE_NAME value")
+		}
+	}
+
+	if ipStr := os.Getenv("SERVERLIST_IP"); ipStr != "" {
+		cfg.IP, err = parseIP(ipStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_IP value")
+		}
+	}
+
+	cfg.IPProviders = defaultIPProviders
+	if cfg.IPv6 {
+		cfg.IPProviders = defaultIPv6Providers
+	}
+	if providersStr := os.Getenv("SERVERLIST_IP_PROVIDERS"); providersStr != "" {
+		cfg.IPProviders = nil
+		for _, p := range strings.Split(providersStr, ",") {
+			p = strings.TrimSpace(p)
+			if _, err := url.ParseRequestURI(p); err != nil {
+				return config{}, errors.AddContext(err, "invalid SERVERLIST_IP_PROVIDERS value")
+			}
+			cfg.IPProviders = append(cfg.IPProviders, p)
+		}
+	}
+
+	cfg.IPTimeout = defaultIPTimeout
+	if timeoutStr := os.Getenv("SERVERLIST_IP_TIMEOUT"); timeoutStr != "" {
+		cfg.IPTimeout, err = time.ParseDuration(timeoutStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_IP_TIMEOUT value")
+		}
+		if cfg.IPTimeout <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_IP_TIMEOUT value, it must be positive")
+		}
+	}
+
+	cfg.IPCachePath = os.Getenv("SERVERLIST_IP_CACHE")
+	if cfg.IPCachePath == "" {
+		cfg.IPCachePath = filepath.Join(os.TempDir(), defaultIPCacheFile)
+	}
+
+	cfg.IPCacheTTL = defaultIPCacheTTL
+	if ttlStr := os.Getenv("SERVERLIST_IP_CACHE_TTL"); ttlStr != "" {
+		cfg.IPCacheTTL, err = time.ParseDuration(ttlStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_IP_CACHE_TTL value")
+		}
+		if cfg.IPCacheTTL < 0 {
+			return config{}, errors.New("invalid SERVERLIST_IP_CACHE_TTL value, it must not be negative")
+		}
+	}
+
+	cfg.StabilizeDelay = defaultStabilizeDelay
+	if delayStr := os.Getenv("SERVERLIST_STABILIZE_DELAY"); delayStr != "" {
+		cfg.StabilizeDelay, err = time.ParseDuration(delayStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_STABILIZE_DELAY value")
+		}
+		if cfg.StabilizeDelay < 0 {
+			return config{}, errors.New("invalid SERVERLIST_STABILIZE_DELAY value, it must not be negative")
+		}
+	}
+
+	cfg.SuccessWindow = defaultSuccessWindow
+	if windowStr := os.Getenv("SERVERLIST_SUCCESS_WINDOW"); windowStr != "" {
+		cfg.SuccessWindow, err = time.ParseDuration(windowStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_SUCCESS_WINDOW value")
+		}
+		if cfg.SuccessWindow <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_SUCCESS_WINDOW value, it must be positive")
+		}
+	}
+
+	cfg.CheckPolls = defaultCheckPolls
+	if pollsStr := os.Getenv("SERVERLIST_CHECK_POLLS"); pollsStr != "" {
+		cfg.CheckPolls, err = strconv.Atoi(pollsStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_CHECK_POLLS value")
+		}
+		if cfg.CheckPolls < 1 {
+			return config{}, errors.New("invalid SERVERLIST_CHECK_POLLS value, it must be at least 1")
+		}
+	}
+
+	cfg.CheckPollDelay = defaultCheckPollDelay
+	if pollDelayStr := os.Getenv("SERVERLIST_CHECK_POLL_DELAY"); pollDelayStr != "" {
+		cfg.CheckPollDelay, err = time.ParseDuration(pollDelayStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_CHECK_POLL_DELAY value")
+		}
+		if cfg.CheckPollDelay <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_CHECK_POLL_DELAY value, it must be positive")
+		}
+	}
+
+	cfg.Spread = defaultSpread
+	if spreadStr := os.Getenv("SERVERLIST_SPREAD"); spreadStr != "" {
+		cfg.Spread, err = time.ParseDuration(spreadStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_SPREAD value")
+		}
+		if cfg.Spread < 0 {
+			return config{}, errors.New("invalid SERVERLIST_SPREAD value, it must not be negative")
+		}
+	}
+
+	cfg.Interval = defaultInterval
+	if intervalStr := os.Getenv("SERVERLIST_INTERVAL"); intervalStr != "" {
+		cfg.Interval, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_INTERVAL value")
+		}
+		if cfg.Interval <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_INTERVAL value, it must be positive")
+		}
+	}
+
+	cfg.IntervalJitter = defaultIntervalJitter
+	if jitterStr := os.Getenv("SERVERLIST_INTERVAL_JITTER"); jitterStr != "" {
+		cfg.IntervalJitter, err = strconv.ParseFloat(jitterStr, 64)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_INTERVAL_JITTER value")
+		}
+		if cfg.IntervalJitter < 0 || cfg.IntervalJitter >= 1 {
+			return config{}, errors.New("invalid SERVERLIST_INTERVAL_JITTER value, it must be at least 0 and less than 1")
+		}
+	}
+
+	cfg.BackoffBase = defaultBackoffBase
+	if baseStr := os.Getenv("SERVERLIST_BACKOFF_BASE"); baseStr != "" {
+		cfg.BackoffBase, err = time.ParseDuration(baseStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_BACKOFF_BASE value")
+		}
+		if cfg.BackoffBase <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_BACKOFF_BASE value, it must be positive")
+		}
+	}
+
+	cfg.BackoffMax = defaultBackoffMax
+	if maxStr := os.Getenv("SERVERLIST_BACKOFF_MAX"); maxStr != "" {
+		cfg.BackoffMax, err = time.ParseDuration(maxStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_BACKOFF_MAX value")
+		}
+	}
+	if cfg.BackoffMax < cfg.BackoffBase {
+		return config{}, errors.New("invalid SERVERLIST_BACKOFF_MAX value, it must not be shorter than SERVERLIST_BACKOFF_BASE")
+	}
+
+	cfg.AttemptTimeout = defaultAttemptTimeout
+	if timeoutStr := os.Getenv("SERVERLIST_ATTEMPT_TIMEOUT"); timeoutStr != "" {
+		cfg.AttemptTimeout, err = time.ParseDuration(timeoutStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_ATTEMPT_TIMEOUT value")
+		}
+	}
+	// Each attempt includes the stabilize delay, so an attempt timeout that
+	// isn't longer than it would make all attempts fail.
+	if cfg.AttemptTimeout <= cfg.StabilizeDelay {
+		return config{}, errors.New("invalid SERVERLIST_ATTEMPT_TIMEOUT value, it must be longer than SERVERLIST_STABILIZE_DELAY")
+	}
+
+	if maxAttemptsStr := os.Getenv("SERVERLIST_MAX_ATTEMPTS"); maxAttemptsStr != "" {
+		cfg.MaxAttempts, err = strconv.Atoi(maxAttemptsStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_MAX_ATTEMPTS value")
+		}
+		if cfg.MaxAttempts < 0 {
+			return config{}, errors.New("invalid SERVERLIST_MAX_ATTEMPTS value, it must not be negative")
+		}
+	}
+
+	cfg.Reread = true
+
+	cfg.MaxShrink = defaultMaxShrink
+	if maxShrinkStr := os.Getenv("SERVERLIST_MAX_SHRINK"); maxShrinkStr != "" {
+		cfg.MaxShrink, err = strconv.ParseFloat(maxShrinkStr, 64)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_MAX_SHRINK value")
+		}
+		if cfg.MaxShrink < 0 || cfg.MaxShrink > 1 {
+			return config{}, errors.New("invalid SERVERLIST_MAX_SHRINK value, it must be between 0 and 1")
+		}
+	}
+
+	if compressStr := os.Getenv("SERVERLIST_COMPRESS"); compressStr != "" {
+		cfg.Compress, err = strconv.ParseBool(compressStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_COMPRESS value")
+		}
+	}
+
+	if prettyStr := os.Getenv("SERVERLIST_PRETTY"); prettyStr != "" {
+		cfg.Pretty, err = strconv.ParseBool(prettyStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_PRETTY value")
+		}
+	}
+
+	cfg.MaxServers = defaultMaxServers
+	if maxServersStr := os.Getenv("SERVERLIST_MAX_SERVERS"); maxServersStr != "" {
+		cfg.MaxServers, err = strconv.Atoi(maxServersStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_MAX_SERVERS value")
+		}
+		if cfg.MaxServers < 1 {
+			return config{}, errors.New("invalid SERVERLIST_MAX_SERVERS value, it must be positive")
+		}
+	}
+
+	cfg.MaxBytes = defaultMaxBytes
+	if maxBytesStr := os.Getenv("SERVERLIST_MAX_BYTES"); maxBytesStr != "" {
+		cfg.MaxBytes, err = strconv.Atoi(maxBytesStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_MAX_BYTES value")
+		}
+		if cfg.MaxBytes < 1 {
+			return config{}, errors.New("invalid SERVERLIST_MAX_BYTES value, it must be positive")
+		}
+	}
+
+	switch strategy := os.Getenv("SERVERLIST_OVERSIZE_STRATEGY"); strategy {
+	case "", oversizeFail:
+		cfg.OversizeStrategy = oversizeFail
+	case oversizeShed:
+		cfg.OversizeStrategy = oversizeShed
+	default:
+		return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_OVERSIZE_STRATEGY value '%s', it must be either %s or %s", strategy, oversizeFail, oversizeShed))
+	}
+
+	cfg.MetricsAddr = os.Getenv("SERVERLIST_METRICS_ADDR")
+
+	cfg.StatusAddr = os.Getenv("SERVERLIST_STATUS_ADDR")
+	cfg.StatusToken = os.Getenv("SERVERLIST_STATUS_TOKEN")
+	if cfg.StatusAddr != "" && cfg.StatusToken == "" {
+		return config{}, errors.New("SERVERLIST_STATUS_TOKEN needs to be set when SERVERLIST_STATUS_ADDR is")
+	}
+
+	cfg.SkylinkFile = os.Getenv("SERVERLIST_SKYLINK_FILE")
+
+	if webhookURL := os.Getenv("SERVERLIST_WEBHOOK_URL"); webhookURL != "" {
+		u, err := url.ParseRequestURI(webhookURL)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_WEBHOOK_URL value")
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return config{}, errors.New("invalid SERVERLIST_WEBHOOK_URL value, it must be an http or https URL")
+		}
+		cfg.WebhookURL = webhookURL
+	}
+
+	cfg.WebhookTimeout = defaultWebhookTimeout
+	if timeoutStr := os.Getenv("SERVERLIST_WEBHOOK_TIMEOUT"); timeoutStr != "" {
+		cfg.WebhookTimeout, err = time.ParseDuration(timeoutStr)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_WEBHOOK_TIMEOUT value")
+		}
+		if cfg.WebhookTimeout <= 0 {
+			return config{}, errors.New("invalid SERVERLIST_WEBHOOK_TIMEOUT value, it must be positive")
+		}
+	}
+
+	cfg.LogLevel = slog.LevelInfo
+	if levelStr := os.Getenv("SERVERLIST_LOG_LEVEL"); levelStr != "" {
+		err = cfg.LogLevel.UnmarshalText([]byte(levelStr))
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_LOG_LEVEL value")
+		}
+	}
+
+	switch format := os.Getenv("SERVERLIST_LOG_FORMAT"); format {
+	case "", "text":
+	case "json":
+		cfg.LogJSON = true
+	default:
+		return config{}, errors.New(fmt.Sprintf("invalid SERVERLIST_LOG_FORMAT value '%s', it must be either text or json", format))
+	}
+
+	// Like skyd, we read the password from a file, so it doesn't need to be
+	// in the environment. The file takes precedence over the env var.
+	if passwordFile := os.Getenv("SERVERLIST_API_PASSWORD_FILE"); passwordFile != "" {
+		cfg.SkydApiPassword, err = readAPIPassword(passwordFile)
+		if err != nil {
+			return config{}, errors.AddContext(err, "invalid SERVERLIST_API_PASSWORD_FILE value")
+		}
+	} else {
+		cfg.SkydApiPassword = os.Getenv("SIA_API_PASSWORD")
+	}
+	if cfg.SkydApiPassword == "" && !readOnly {
+		return config{}, errors.AddContext(ErrMissingAPIPassword, "failed to get api password. is SIA_API_PASSWORD or SERVERLIST_API_PASSWORD_FILE env var defined?")
+	}
+
+	return cfg, nil
+}
+
+// readAPIPassword reads the skyd API password from the file at path, e.g.
+// ~/.sia/apipassword. Surrounding whitespace isn't part of the password.
+func readAPIPassword(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to read api password file")
+	}
+	password := strings.TrimSpace(string(b))
+	if password == "" {
+		return "", errors.AddContext(ErrMissingAPIPassword, "api password file "+path+" is empty")
+	}
+	return password, nil
+}
+
+// parseOwnName extracts the name under which we announce the server from the
+// given URL or host, e.g. https://dev1.siasky.dev results in dev1.siasky.dev.
+// Paths, queries, and user info are rejected. The canonical name never
+// contains a port, so a port is returned separately. It's zero if there's no
+// port or if it's the default one of the scheme, e.g. https://dev1.siasky.dev:443
+// and dev1.siasky.dev:443 both result in dev1.siasky.dev and zero.
+func parseOwnName(s string) (string, int, error) {
+	rawURL := s
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, errors.AddContext(err, "failed to parse server name")
+	}
+	if u.Path != "" && u.Path != "/" {
+		return "", 0, errors.New(fmt.Sprintf("server name '%s' must not contain a path", s))
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", 0, errors.New(fmt.Sprintf("server name '%s' must be a plain host", s))
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) == nil && (len(host) > 253 || !hostnameRegex.MatchString(host)) {
+		return "", 0, errors.New(fmt.Sprintf("'%s' is not a valid hostname", host))
+	}
+	if u.Port() == "" {
+		return host, 0, nil
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, errors.New(fmt.Sprintf("server name '%s' has an invalid port", s))
+	}
+	if port == defaultSchemePorts[u.Scheme] {
+		return host, 0, nil
+	}
+	return host, port, nil
+}
+
+// parseLabels parses labels in the k1=v1,k2=v2 format. Keys and values must
+// not be empty and keys must be unique.
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			return nil, errors.New(fmt.Sprintf("label '%s' must have the form key=value", pair))
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == "" || value == "" {
+			return nil, errors.New(fmt.Sprintf("label '%s' must have a non-empty key and value", pair))
+		}
+		if _, exists := labels[key]; exists {
+			return nil, errors.New(fmt.Sprintf("duplicate label '%s'", key))
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// parseIP validates the given IPv4 or IPv6 address and returns it in its
+// canonical form.
+func parseIP(s string) (string, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return "", errors.New(fmt.Sprintf("'%s' is not a valid ip address", s))
+	}
+	return ip.String(), nil
+}
+
+// parseHostIP parses an IP address which may come with a port attached, e.g.
+// 1.2.3.4:8080 or [::1]:443. It only returns the IP and returns nil if the
+// host is not a valid IP.
+func parseHostIP(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(s)
+}
+
+// getOwnIP uses an external service in order to discover our external IP. The
+// endpoint is expected to respond with a plain text IPv4 or IPv6 address. If no
+// client is given we use http.DefaultClient and if no endpoint is given we use
+// ipify's IPv4 endpoint.
+func getOwnIP(ctx context.Context, c *http.Client, endpoint string) (string, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	if endpoint == "" {
+		endpoint = ipifyURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to create request to "+endpoint)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to query "+endpoint)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("failed to query %s, status code %d", endpoint, resp.StatusCode))
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to read "+endpoint+" response")
+	}
+	// Some providers terminate the IP with a newline.
+	body := strings.TrimSpace(string(bodyBytes))
+	if body == "" {
+		return "", errors.New(fmt.Sprintf("empty response from %s", endpoint))
+	}
+	ip := parseHostIP(body)
+	if ip == nil {
+		return "", errors.New(fmt.Sprintf("invalid ip received from %s '%s'", endpoint, body))
+	}
+	return ip.String(), nil
+}
+
+// resolveIPs returns the IPs the given name resolves to, sorted so they don't
+// change with the order of the DNS response.
+func resolveIPs(ctx context.Context, name string) ([]string, error) {
+	addrs, err := lookupHost(ctx, name)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to resolve "+name)
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, errors.New(fmt.Sprintf("invalid ip '%s' resolved for %s", addr, name))
+		}
+		ips = append(ips, ip.String())
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no ips resolved for " + name)
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// discoverIP queries the given IP providers in order and returns the first
+// valid IP one of them responds with.
+func discoverIP(ctx context.Context, c *http.Client, providers []string) (string, error) {
+	var errs error
+	for _, p := range providers {
+		ip, err := getOwnIP(ctx, c, p)
+		if err != nil {
+			logger.Debug("ip provider failed", "provider", p, "error", err)
+			errs = errors.Compose(errs, err)
+			continue
+		}
+		logger.Info("discovered own ip", "provider", p, "ip", ip)
+		return ip, nil
+	}
+	return "", errors.AddContext(errs, "all ip providers failed")
+}
+
+// ownIPFunc returns the function with which we get our external IP. A
+// configured IP takes precedence, then skyd's gateway address, if configured,
+// and finally the IP providers, whose answer we cache unless the cache is
+// disabled or refresh is set.
+func ownIPFunc(cfg config, skyd skydClient, c *http.Client, refresh bool) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		// Operators behind NAT know our public IP better than any provider.
+		if cfg.IP != "" {
+			return cfg.IP, nil
+		}
+		// A full node already knows its public address, so we don't need to
+		// ask a third party.
+		if cfg.IPFromSkyd {
+			ip, err := skydIP(ctx, skyd)
+			if err == nil {
+				return ip, nil
+			}
+			logger.Warn("failed to get own ip from skyd, discovering it instead", "error", err)
+		}
+		lookup := func() (string, error) {
+			return discoverIP(ctx, c, cfg.IPProviders)
+		}
+		if cfg.IPCacheTTL == 0 {
+			return lookup()
+		}
+		providers := strings.Join(cfg.IPProviders, ",")
+		return cachedIP(cfg.IPCachePath, providers, cfg.IPCacheTTL, refresh, lookup)
+	}
+}
+
+// checkSuccess fetches the list of servers and ensures that this server's
+// record and the records of its aliases were updated within cfg.SuccessWindow
+// and that they match the ones on the given list we wrote. It returns an error
+// describing why the check failed.
+func checkSuccess(ctx context.Context, db skyDB, cfg config, tweak [32]byte, wrote []server) error {
+	list, _, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		return errors.AddContext(err, "failed to check for "+cfg.OwnName)
+	}
+	err = checkRecord(list, wrote, tweak, cfg.OwnName, cfg.NodeID, cfg.SuccessWindow)
+	for _, alias := range cfg.AliasNames {
+		err = errors.Compose(err, checkRecord(list, wrote, tweak, alias, "", cfg.SuccessWindow))
+	}
+	return err
+}
+
+// pollSuccess runs checkSuccess up to cfg.CheckPolls times, backing off in
+// between, until a check passes. Writes can take a while to propagate, and
+// reading the list again is cheaper than re-announcing. It returns the error of
+// the last check.
+func pollSuccess(ctx context.Context, db skyDB, cfg config, tweak [32]byte, wrote []server, l *slog.Logger) error {
+	var err error
+	for poll := 1; poll <= cfg.CheckPolls; poll++ {
+		err = checkSuccess(ctx, db, cfg, tweak, wrote)
+		// Reading again won't fix a wrong password.
+		if err == nil || errors.Contains(err, ErrAuthFailed) {
+			return err
+		}
+		if poll == cfg.CheckPolls {
+			break
+		}
+		d := backoffDuration(poll, cfg.CheckPollDelay, maxCheckPollDelay)
+		// We never sleep past the deadline of the context, so we give up
+		// as soon as it expires.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			d = time.Until(deadline)
+		}
+		l.Debug("success check failed, checking again", "poll", poll, "backoff", d, "error", err)
+		if !sleep(ctx, d) {
+			return errors.Compose(err, ctx.Err())
+		}
+	}
+	return err
+}
+
+// checkRecord ensures that the record of the server with the given name and
+// node ID on the given list was updated within the given window. In a race,
+// another server can write back our record with a recent announce time but
+// with outdated fields, so we also compare the fields which matter to the
+// consumers of the list to the record we wrote, if it's on the list we wrote.
+func checkRecord(list, wrote []server, tweak [32]byte, ownName, nodeID string, window time.Duration) error {
+	for _, s := range list {
+		if !isServer(s, ownName, nodeID) {
+			continue
+		}
+		if !s.LastAnnounce.After(clock().Add(-window)) {
+			return errors.New(fmt.Sprintf("record of %s on list %s was last updated at %s", ownName, tweakID(tweak), s.LastAnnounce.Format(time.RFC3339)))
+		}
+		if i := ownRecordIndex(wrote, ownName, nodeID); i >= 0 {
+			return compareRecord(s, wrote[i], tweak)
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%s is not on list %s", ownName, tweakID(tweak)))
+}
+
+// compareRecord ensures that the stored record has the same address as the
+// record we wrote.
+func compareRecord(stored, wrote server, tweak [32]byte) error {
+	if stored.IP != wrote.IP {
+		return errors.New(fmt.Sprintf("record of %s on list %s has ip %s instead of %s", wrote.Name, tweakID(tweak), stored.IP, wrote.IP))
+	}
+	if stored.Port != wrote.Port {
+		return errors.New(fmt.Sprintf("record of %s on list %s has port %d instead of %d", wrote.Name, tweakID(tweak), stored.Port, wrote.Port))
+	}
+	if strings.Join(stored.IPs, ",") != strings.Join(wrote.IPs, ",") {
+		return errors.New(fmt.Sprintf("record of %s on list %s has ips %v instead of %v", wrote.Name, tweakID(tweak), stored.IPs, wrote.IPs))
+	}
+	return nil
+}
+
+// backoffDuration returns the time we should wait before retrying after the
+// given number of failed attempts. The backoff is min(base*2^(attempt-1),
+// maxBackoff), so it starts at base and doubles with each attempt. We add equal
+// jitter, i.e. we wait for half of the backoff plus a random part of the other
+// half, so servers that failed together don't retry together.
+func backoffDuration(attempt int, base, maxBackoff time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(fastrand.Uint64n(uint64(d/2)+1))
+}
+
+// spreadDuration returns a random duration between zero and the given window,
+// inclusive.
+func spreadDuration(window time.Duration) time.Duration {
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(fastrand.Uint64n(uint64(window) + 1))
+}
+
+// jitteredInterval returns the given interval, randomly shortened or
+// lengthened by up to the given fraction of it. The result averages out to the
+// interval.
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	maxJitter := time.Duration(float64(interval) * jitter)
+	if maxJitter <= 0 {
+		return interval
+	}
+	return interval - maxJitter + time.Duration(fastrand.Uint64n(2*uint64(maxJitter)+1))
+}
+
+// sleep blocks for the given duration or until the context is done, whichever
+// comes first. It returns false if the context is done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// classifyError determines how we handle the given error of a failed attempt.
+func classifyError(err error) errorClass {
+	switch {
+	case errors.Contains(err, ErrAuthFailed):
+		return errAuth
+	case errors.Contains(err, ErrRevisionConflict):
+		return errConflict
+	case errors.Contains(err, ErrListShrunk), errors.Contains(err, ErrListTooLarge), errors.Contains(err, ErrTooManyServers):
+		return errRefused
+	default:
+		return errTransient
+	}
+}
+
+// isAuthError checks whether the given skyd error was caused by a wrong API
+// password. Like revision conflicts, we have to match these by their text.
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), skydAuthError)
+}
+
+// withRetries calls attempt until it succeeds or we run out of attempts,
+// backing off between failed attempts. It records the attempts in m.
+func withRetries(ctx context.Context, cfg config, m *metrics, attempt func(context.Context, *slog.Logger) error) error {
+	// conflict is set when our last write lost a revision race. In that case
+	// we know that the list has changed, so we re-read it right away instead
+	// of backing off.
+	conflict := false
+	var lastErr error
+	for i := 1; cfg.MaxAttempts == 0 || i <= cfg.MaxAttempts; i++ {
+		if i > 1 && !conflict {
+			// back off to allow other servers to finish their updates without
+			// running into a series of races
+			sleepDur := backoffDuration(i-1, cfg.BackoffBase, cfg.BackoffMax)
+			logger.Info("update was unsuccessful, backing off", "attempt", i, "sleep", sleepDur.Round(time.Millisecond))
+			if !sleep(ctx, sleepDur) {
+				return ctx.Err()
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		m.recordAttempt()
+		l := logger.With("attempt", i)
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+		err := runAttempt(attemptCtx, l, attempt)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if timedOut {
+			l.Warn("attempt timed out", "timeout", cfg.AttemptTimeout)
+		}
+		if err == nil {
+			return nil
+		}
+		class := classifyError(err)
+		// Retrying won't fix a wrong password or a list we refuse to write.
+		if class == errAuth || class == errRefused {
+			return errors.AddContext(err, "not retrying")
+		}
+		conflict = class == errConflict
+		lastErr = err
+	}
+	// We keep the last error, so callers can tell why we gave up.
+	return errors.AddContext(lastErr, fmt.Sprintf("failed after %d attempts", cfg.MaxAttempts))
+}
+
+// runAttempt runs a single attempt and turns a panic during it into a failed
+// attempt, so an unexpected panic, e.g. in a dependency, doesn't take down the
+// whole daemon.
+func runAttempt(ctx context.Context, l *slog.Logger, attempt func(context.Context, *slog.Logger) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.Error("attempt panicked", "panic", r, "stack", string(debug.Stack()))
+			err = errors.New(fmt.Sprintf("attempt panicked: %v", r))
+		}
+	}()
+	return attempt(ctx, l)
+}
+
+// writeContext returns a context for writing to SkyDB during an attempt with
+// the given context. A shutdown signal must not abandon a write that has
+// already started, so only the attempt's deadline can interrupt it.
+func writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	writeCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(writeCtx, deadline)
+	}
+	return writeCtx, func() {}
+}
+
+// rereadList reads the list again right before we write it, which shrinks the
+// window in which another server can update the list between our read and our
+// write. If the list has changed since we read it at revision rev, we apply our
+// own record from the given list to the fresh one. It returns the list we
+// should write and the revision it's based on.
+func rereadList(ctx context.Context, db skyDB, tweak [32]byte, list []server, rev uint64, cfg config) ([]server, uint64, error) {
+	fresh, freshRev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+	if freshRev == rev {
+		return list, rev, nil
+	}
+	logger.Debug("server list changed since we read it", "revision", rev, "fresh_revision", freshRev)
+	if ownRecordIndex(list, cfg.OwnName, cfg.NodeID) < 0 {
+		return nil, 0, errors.New("our own record is missing from the list")
+	}
+	return pruneServers(mergeLists(fresh, ownRecords(list, cfg)), cfg), freshRev, nil
+}
+
+// announce gets the latest server list, updates it and saves it. Then it
+// verifies that we're in the list with a recent record. If that's not true it
+// sleeps for a while and tries again, unless we've run out of attempts. It
+// returns the list we've written. If the context gets cancelled we stop
+// retrying but we let an already started write complete. The progress of the
+// process is recorded in the given metrics and the lists we read and write are
+// kept in the given cache.
+func announce(ctx context.Context, db skyDB, skyd skydClient, cfg config, tweak [32]byte, getIP func(context.Context) (string, error), m *metrics, lc *listCache) ([]server, error) {
+	var list, pending []server
+	err := withRetries(ctx, cfg, m, func(ctx context.Context, l *slog.Logger) error {
+		var err error
+		list, err = announceAttempt(ctx, db, skyd, cfg, tweak, getIP, m, lc, pending, l.With("list", tweakID(tweak)))
+		// After a conflict, we merge the records we meant to write onto
+		// the latest list instead of starting over.
+		pending = nil
+		if errors.Contains(err, ErrRevisionConflict) {
+			pending = ownRecords(list, cfg)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to announce")
+	}
+	return list, nil
+}
+
+// announceAttempt makes a single attempt to add our record to the list under
+// the given tweak and returns the list it wrote. It logs and records the
+// failure of each stage. When we lose a revision race the returned error
+// contains ErrRevisionConflict and the returned list is the one we tried to
+// write. Our records from that list can be passed back as pending, in which
+// case we merge them onto the list instead of updating our record again, see
+// mergeLists. An already started write is only interrupted by the context's
+// deadline, not by its cancellation.
+func announceAttempt(ctx context.Context, db skyDB, skyd skydClient, cfg config, tweak [32]byte, getIP func(context.Context) (string, error), m *metrics, lc *listCache, pending []server, l *slog.Logger) ([]server, error) {
+	// We time each stage, so we can tell where slow announces spend their
+	// time.
+	var readDur, ipDur, writeDur, checkDur time.Duration
+	timedGetIP := func(ctx context.Context) (string, error) {
+		start := time.Now()
+		defer func() {
+			ipDur = time.Since(start)
+			m.recordDuration(stageIP, ipDur)
+		}()
+		return getIP(ctx)
+	}
+
+	start := time.Now()
+	list, rev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	readDur = time.Since(start)
+	m.recordDuration(stageRead, readDur)
+	// When the read fails transiently, we apply our record to the last list we
+	// know instead. If that list is outdated, the write fails with a conflict.
+	fromCache := false
+	if err != nil {
+		l.Error("failed to get server list", "error", err)
+		m.recordFailure(stageRead)
+		cached, cachedRev, ok := lc.load(tweak)
+		if !ok || classifyError(err) != errTransient || ctx.Err() != nil {
+			return nil, err
+		}
+		l.Warn("falling back to the last known good list", "cached_revision", cachedRev)
+		list, rev, fromCache = cached, cachedRev, true
+		l = l.With("from_cache", true)
+	} else {
+		lc.store(tweak, list, rev)
+	}
+	l = l.With("revision", rev)
+	m.recordServers(len(list))
+	var updatedList []server
+	if len(pending) > 0 {
+		// Our records are still fresh, so there's no need to look them up
+		// again.
+		l.Debug("merging pending records onto the list", "records", len(pending))
+		updatedList = mergeLists(list, pending)
+	} else {
+		updatedList, _, err = updateOwnRecord(ctx, list, cfg, timedGetIP, skyd)
+		if err != nil {
+			l.Error("failed to update list", "servers", len(list), "error", err)
+			m.recordFailure(stageUpdate)
+			return nil, err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	cleanList := pruneServers(updatedList, cfg)
+	// Writing a list without our record would be pointless, so we fail
+	// before the write rather than after the success check.
+	err = ensureOwnRecord(cleanList, cfg.OwnName, cfg.NodeID)
+	if err != nil {
+		l.Error("failed to update list", "servers", len(cleanList), "error", err)
+		m.recordFailure(stageUpdate)
+		return nil, err
+	}
+	// The read just failed, so there's no point in reading again.
+	if cfg.Reread && !fromCache {
+		cleanList, rev, err = rereadList(ctx, db, tweak, cleanList, rev, cfg)
+		if err != nil {
+			l.Error("failed to re-read server list", "error", err)
+			m.recordFailure(stageRead)
+			return nil, err
+		}
+	}
+	cleanList = signOwnRecords(cleanList, cfg)
+	fitted, err := fitList(cleanList, cfg)
+	if err != nil {
+		l.Error("refusing to write server list", "servers", len(cleanList), "error", err)
+		m.recordFailure(stageUpdate)
+		return nil, err
+	}
+	cleanList = fitted
+	if !cfg.AllowShrink {
+		err = checkShrink(list, cleanList, cfg)
+		if err != nil {
+			l.Error("refusing to write server list", "servers", len(cleanList), "read_servers", len(list), "error", err)
+			m.recordFailure(stageUpdate)
+			return nil, err
+		}
+	}
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	start = time.Now()
+	err = putServerList(writeCtx, db, cleanList, tweak, rev+1, cfg)
+	writeDur = time.Since(start)
+	m.recordDuration(stageWrite, writeDur)
+	if errors.Contains(err, ErrRevisionConflict) {
+		l.Warn("revision conflict, retrying right away", "servers", len(cleanList))
+		m.recordFailure(stageWrite)
+		if fromCache {
+			// The cached list is outdated, so we must not fall back to it
+			// again.
+			lc.drop(tweak)
+		}
+		return cleanList, err
+	}
+	if err != nil {
+		l.Error("failed to update server list", "servers", len(cleanList), "error", err)
+		m.recordFailure(stageWrite)
+		return nil, err
+	}
+	lc.store(tweak, cleanList, rev+1)
+	// We want to sleep here for a bit in order to give the system time to
+	// stabilize, otherwise we can run into a race where two machines write
+	// different data for the same revision and both get positive responses
+	// but only one of them gets selected as winner and gets their data
+	// persisted. This delay is paid on every attempt that gets to write, on
+	// top of the backoff before the next attempt if the check fails, so a long
+	// delay slows down every announce, while a short one makes it more likely
+	// that we miss a lost race and only find out about it on our next run.
+	if !sleep(ctx, cfg.StabilizeDelay) {
+		return nil, ctx.Err()
+	}
+	start = time.Now()
+	err = pollSuccess(ctx, db, cfg, tweak, cleanList, l)
+	checkDur = time.Since(start)
+	m.recordDuration(stageCheck, checkDur)
+	if err != nil {
+		l.Warn("success check failed", "servers", len(cleanList), "error", err)
+		m.recordFailure(stageCheck)
+		return nil, errors.AddContext(err, "success check failed")
+	}
+	l.Info("announced successfully", "servers", len(cleanList),
+		"read_ms", readDur.Milliseconds(),
+		"ip_ms", ipDur.Milliseconds(),
+		"write_ms", writeDur.Milliseconds(),
+		"check_ms", checkDur.Milliseconds(),
+	)
+	m.recordServers(len(cleanList))
+	m.recordSuccess()
+	return cleanList, nil
+}
+
+// removeServer removes all entries of the server with the given name and node
+// ID from the list, see isServer. It returns the updated list and the number of
+// removed entries.
+func removeServer(list []server, name, id string) ([]server, int) {
+	var updatedList []server
+	for _, s := range list {
+		if !isServer(s, name, id) {
+			updatedList = append(updatedList, s)
+		}
+	}
+	return updatedList, len(list) - len(updatedList)
+}
+
+// deregister removes our own record and the records of our aliases from the
+// list under the given tweak. It's not an error if we're not on the list. It
+// retries just like announce does.
+func deregister(ctx context.Context, db skyDB, cfg config, tweak [32]byte, m *metrics) error {
+	return withRetries(ctx, cfg, m, func(ctx context.Context, l *slog.Logger) error {
+		_, err := removeAttempt(ctx, db, cfg, tweak, cfg.OwnName, cfg.NodeID, l)
+		if err != nil {
+			return err
+		}
+		for _, alias := range cfg.AliasNames {
+			_, err = removeAttempt(ctx, db, cfg, tweak, alias, "", l)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// evict removes all servers with the given name from the list under the given
+// tweak, regardless of their age. It returns the number of removed servers and
+// retries just like announce does.
+func evict(ctx context.Context, db skyDB, cfg config, tweak [32]byte, name string, m *metrics) (int, error) {
+	removed := 0
+	err := withRetries(ctx, cfg, m, func(ctx context.Context, l *slog.Logger) error {
+		var err error
+		removed, err = removeAttempt(ctx, db, cfg, tweak, name, "", l)
+		return err
+	})
+	return removed, err
+}
+
+// removeAttempt makes a single attempt to remove the server with the given
+// name and node ID from the list under the given tweak. It returns the number of
+// removed entries. If the server is not on the list, it returns zero without
+// writing anything.
+func removeAttempt(ctx context.Context, db skyDB, cfg config, tweak [32]byte, name, id string, l *slog.Logger) (int, error) {
+	list, rev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		l.Error("failed to get server list", "error", err)
+		return 0, err
+	}
+	l = l.With("revision", rev, "name", name)
+	if id != "" {
+		l = l.With("id", id)
+	}
+	updatedList, removed := removeServer(list, name, id)
+	if removed == 0 {
+		l.Info("server is not on the list, nothing to remove")
+		return 0, nil
+	}
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	err = putServerList(writeCtx, db, updatedList, tweak, rev+1, cfg)
+	if err != nil {
+		l.Error("failed to update server list", "servers", len(updatedList), "error", err)
+		return 0, err
+	}
+	// Give the system time to stabilize before we check, see announceAttempt.
+	if !sleep(ctx, cfg.StabilizeDelay) {
+		return 0, ctx.Err()
+	}
+	list, _, err = getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		l.Error("failed to get server list", "error", err)
+		return 0, err
+	}
+	if _, remaining := removeServer(list, name, id); remaining > 0 {
+		l.Warn("server is still on the list")
+		return 0, errors.New("server is still on the list")
+	}
+	l.Info("removed server from the list", "removed", removed, "servers", len(updatedList))
+	return removed, nil
+}
+
+// planList computes the list we would write to SkyDB under the given tweak. It
+// returns the stored list, the list we would write and the revision of the
+// stored list.
+func planList(ctx context.Context, db skyDB, skyd skydClient, cfg config, tweak [32]byte, getIP func(context.Context) (string, error)) ([]server, []server, uint64, error) {
+	list, rev, err := getServerList(ctx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		return nil, nil, 0, errors.AddContext(err, "failed to get server list")
+	}
+	// updateOwnRecord modifies the list in place, so we keep a copy of the
+	// stored list.
+	stored := append([]server(nil), list...)
+	updatedList, _, err := updateOwnRecord(ctx, list, cfg, getIP, skyd)
+	if err != nil {
+		return nil, nil, 0, errors.AddContext(err, "failed to update list")
+	}
+	cleanList := pruneServers(updatedList, cfg)
+	err = ensureOwnRecord(cleanList, cfg.OwnName, cfg.NodeID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	cleanList, err = fitList(signOwnRecords(cleanList, cfg), cfg)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return stored, cleanList, rev, nil
+}
+
+// dryRun computes the list we would write to SkyDB under the given tweak and
+// prints it together with the revision we'd write it at and how it differs
+// from the stored list, without actually writing anything.
+func dryRun(ctx context.Context, db skyDB, skyd skydClient, cfg config, tweak [32]byte, getIP func(context.Context) (string, error)) error {
+	stored, cleanList, rev, err := planList(ctx, db, skyd, cfg, tweak, getIP)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cleanList, "", "  ")
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal server list")
+	}
+	fmt.Printf("dry run, would write revision %d:\n%s\n", rev+1, string(b))
+	added, updated, removed := diffLists(stored, cleanList)
+	printDiff("added", added)
+	printDiff("updated", updated)
+	printDiff("removed", removed)
+	return nil
+}
+
+// printDiff prints the servers in one section of a diff, see diffLists.
+func printDiff(section string, list []server) {
+	fmt.Printf("%s: %d\n", section, len(list))
+	for _, s := range list {
+		fmt.Printf("  %s %s %s\n", s.Name, s.IP, s.LastAnnounce.Format(time.RFC3339))
+	}
+}
+
+// verifySkylink resolves the given skylink through skyd and verifies that it
+// points to the given list, exactly as we stored it, apart from compression.
+func verifySkylink(ctx context.Context, skyd skydClient, skylink string, list []server, pretty bool) error {
+	expected, err := marshalServerList(list, pretty)
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal server list")
+	}
+	var data []byte
+	ctxErr := withContext(ctx, func() {
+		data, err = skyd.SkynetSkylinkGet(skylink)
+	})
+	if ctxErr != nil {
+		return errors.AddContext(ctxErr, "failed to download "+skylink)
+	}
+	if err != nil {
+		return errors.AddContext(err, "failed to download "+skylink)
+	}
+	data, err = decompressList(data)
+	if err != nil {
+		return errors.AddContext(err, "failed to decompress "+skylink)
+	}
+	if !bytes.Equal(data, expected) {
+		return errors.New(fmt.Sprintf("skylink %s doesn't point to the list we wrote", skylink))
+	}
+	return nil
+}
+
+// announceAll announces to each list independently, so a failure on one of
+// them doesn't prevent us from appearing on the others, unless skyd rejects our
+// API password, in which case we return right away. It prints the result
+// for each list we announce to successfully and returns the errors of the lists
+// we failed to announce to. If verify is set, we also verify that each skylink
+// resolves to the list we wrote. The outcome of each announce is recorded in
+// the given status and reported to the given webhook.
+func announceAll(ctx context.Context, db skyDB, skyd skydClient, cfg config, pk crypto.PublicKey, getIP func(context.Context) (string, error), m *metrics, lc *listCache, st *status, wh *webhook, output string, verify bool) ([]error, error) {
+	var failures []error
+	for i, tweak := range cfg.Tweaks {
+		sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+		finalList, err := announce(ctx, db, skyd, cfg, tweak, getIP, m, lc)
+		if errors.Contains(err, context.Canceled) {
+			return failures, err
+		}
+		// A wrong API password affects all lists, so there's no point in
+		// trying the others. We still report it, since it needs an operator.
+		if errors.Contains(err, ErrAuthFailed) {
+			st.recordError(err)
+			notifyWebhook(ctx, wh, cfg, sl.String(), err)
+			return failures, err
+		}
+		if err != nil {
+			logger.Error("failed to announce", "skylink", sl.String(), "error", err)
+			st.recordError(err)
+			notifyWebhook(ctx, wh, cfg, sl.String(), err)
+			failures = append(failures, err)
+			continue
+		}
+		st.recordAnnounce(sl.String(), finalList, cfg.OwnName, cfg.NodeID)
+		notifyWebhook(ctx, wh, cfg, sl.String(), nil)
+		if backup, ok := backupTweak(cfg, i); ok {
+			writeBackup(ctx, db, cfg, backup, finalList)
+		}
+		if verify {
+			verifyCtx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			err = verifySkylink(verifyCtx, skyd, sl.String(), finalList, cfg.Pretty)
+			cancel()
+			if err != nil {
+				// Another server might have legitimately updated the list in
+				// the meantime, so this is just a warning.
+				logger.Warn("failed to verify skylink", "skylink", sl.String(), "error", err)
+			}
+		}
+		// output the skylink. this serves as a confirmation of a successful
+		// run and as a handy way to get the skylink.
+		err = printResult(output, sl.String(), finalList)
+		if err != nil {
+			return failures, err
+		}
+	}
+	return failures, nil
+}
+
+// notifyWebhook reports the outcome of an announce to the given list to the
+// webhook. Failing to do so doesn't affect the announce, so we only log it.
+func notifyWebhook(ctx context.Context, wh *webhook, cfg config, skylink string, announceErr error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.WebhookTimeout)
+	defer cancel()
+	err := wh.notify(ctx, cfg.OwnName, skylink, announceErr)
+	if err != nil {
+		logger.Warn("failed to notify webhook", "skylink", skylink, "error", err)
+	}
+}
+
+// printList prints the given list in the given output format, together with
+// the staleness of each server, see annotateStaleness. The JSON format extends
+// the one of printResult with the staleness fields.
+func printList(output, skylink string, list []server, staleAfter time.Duration) error {
+	annotated := annotateStaleness(list, clock(), staleAfter)
+	if output == outputJSON {
+		b, err := json.MarshalIndent(listResult{Skylink: skylink, Servers: annotated}, "", "  ")
+		if err != nil {
+			return errors.AddContext(err, "failed to marshal list")
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	fmt.Printf("%s\n", skylink)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tIP\tPORT\tHEALTHY\tLAST ANNOUNCE\tSTALENESS")
+	for _, s := range annotated {
+		staleness := fmt.Sprintf("%.2f", s.Staleness)
+		if s.Stale {
+			staleness += " (stale)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%s\t%s\n", s.Name, s.IP, s.Port, s.Healthy, s.LastAnnounce.Format(time.RFC3339), staleness)
+	}
+	return w.Flush()
+}
+
+// printRaw prints the given stored bytes of the list with the given skylink,
+// either verbatim or as a hex dump.
+func printRaw(asHex bool, skylink string, rev uint64, b []byte) {
+	fmt.Printf("%s revision %d, %d bytes:\n", skylink, rev, len(b))
+	if asHex {
+		fmt.Println(hex.Dump(b))
+		return
+	}
+	fmt.Printf("%s\n", b)
+}
+
+// printResult prints the result of a successful announce in the given output
+// format. In JSON format each list results in a separate JSON object.
+func printResult(output, skylink string, list []server) error {
+	if output == outputJSON {
+		res := announceResult{
+			Skylink: skylink,
+			Servers: list,
+		}
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return errors.AddContext(err, "failed to marshal result")
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	fmt.Printf("skylink updated successfully: %s\n", skylink)
+	return nil
+}
+
+func main() {
+	os.Exit(start())
+}
+
+// start parses the flags and the config, sets up the dependencies of the tool,
+// and runs it. It returns the exit code, see the exit* constants.
+func start() int {
+	once := flag.Bool("once", false, "make a single announce attempt and exit, regardless of SERVERLIST_MAX_ATTEMPTS")
+	output := flag.String("output", outputText, "output format, either text or json")
+	dry := flag.Bool("dry-run", false, "print the list we would write and exit without writing it")
+	diffOnlyFlag := flag.Bool("diff-only", false, "report whether an announce would change the list and exit without writing it")
+	watchInterval := flag.Duration("watch", 0, "print the changes of the lists every given interval, e.g. 10s, without ever writing them")
+	observeOnly := flag.Bool("observe", false, "keep reading the lists every SERVERLIST_INTERVAL and expose them via metrics and status, without ever writing them")
+	evictName := flag.String("evict", "", "remove all servers with this name from the list, regardless of their age, and exit")
+	importPath := flag.String("import", "", "merge the servers from this JSON file into the list and exit")
+	confirm := flag.Bool("yes", false, "confirm destructive operations like -evict")
+	deregisterSelf := flag.Bool("deregister", false, "remove this server from the list and exit")
+	noSpread := flag.Bool("no-spread", false, "don't delay the first announce by a random amount of time")
+	verifySL := flag.Bool("verify-skylink", false, "verify that the skylink resolves to the list we wrote")
+	daemon := flag.Bool("daemon", false, "keep running and re-announce every SERVERLIST_INTERVAL")
+	metricsSnapshot := flag.String("metrics-snapshot", "", "write a JSON snapshot of the metrics to the given file, or to stdout for -, on exit")
+	staleOnly := flag.Bool("stale", false, "print the servers which haven't announced for SERVERLIST_STALE_AFTER and exit without announcing")
+	listOnly := flag.Bool("list", false, "print the current server list and exit without announcing")
+	configPath := flag.String("config", "", "path to a YAML or JSON config file, env vars take precedence over its values")
+	refreshIP := flag.Bool("refresh-ip", false, "look up our external ip even if we have a fresh one cached")
+	check := flag.Bool("check", false, "check that skyd is reachable, that it accepts the api password and that the lists are readable, then exit")
+	raw := flag.Bool("raw", false, "print the stored bytes of each list and their revision and exit, without parsing them")
+	rawHex := flag.Bool("hex", false, "print the bytes printed by -raw as a hex dump")
+	readBackup := flag.Bool("read-backup", false, "make -list and -stale read the backup of a list if reading the list fails")
+	skylinkFile := flag.String("skylink-file", "", "write the skylinks to this file after a successful announce, overrides SERVERLIST_SKYLINK_FILE")
+	validatePath := flag.String("validate", "", "validate the list in this JSON file, print the problems, and exit without touching skydb")
+	printCfg := flag.Bool("print-config", false, "print the effective config as JSON, with secrets redacted, and exit")
+	printSkylink := flag.Bool("skylink", false, "print the skylink of each list and exit without talking to skyd")
+	noReread := flag.Bool("no-reread", false, "don't read the list again right before writing it")
+	allowShrink := flag.Bool("allow-shrink", false, "write the list even if it drops more than SERVERLIST_MAX_SHRINK of its servers")
+	trim := flag.Bool("trim", false, "drop the servers with the oldest announces when the list exceeds SERVERLIST_MAX_SERVERS")
+	forceIP := flag.String("ip", "", "announce this ip instead of discovering our external one, overrides SERVERLIST_IP")
+	flag.Parse()
+
+	logOut := os.Stdout
+	switch *output {
+	case outputText:
+	case outputJSON:
+		logOut = os.Stderr
+	default:
+		log.Printf("invalid output format '%s'", *output)
+		return exitConfig
+	}
+
+	// Validating a file needs neither the config nor skyd.
+	if *validatePath != "" {
+		problems, err := validateListFile(*validatePath, nil)
+		if err != nil {
+			log.Print(err)
+			return exitFailure
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			log.Printf("found %d problems", len(problems))
+			return exitFailure
+		}
+		fmt.Println("no problems found")
+		return exitSuccess
+	}
+
+	err := loadEnvFiles(flag.Args())
+	if err != nil {
+		log.Print(errors.AddContext(err, "failed to load .env"))
+		return exitConfig
+	}
+	if *configPath != "" {
+		err = loadConfigFile(*configPath)
+		if err != nil {
+			log.Print(errors.AddContext(err, "failed to load config file"))
+			return exitConfig
+		}
+	}
+	configFunc := getConfig
+	if *observeOnly || *printSkylink {
+		configFunc = getReadOnlyConfig
+	}
+	cfg, err := configFunc()
+	if err != nil {
+		log.Print(errors.AddContext(err, "failed to read config"))
+		return exitConfig
+	}
+	if *once {
+		cfg.MaxAttempts = 1
+	}
+	cfg.TrimServers = *trim
+	cfg.AllowShrink = *allowShrink
+	if *skylinkFile != "" {
+		cfg.SkylinkFile = *skylinkFile
+	}
+	if *noReread {
+		cfg.Reread = false
+	}
+	if *forceIP != "" {
+		cfg.IP, err = parseIP(*forceIP)
+		if err != nil {
+			log.Print(errors.AddContext(err, "invalid -ip value"))
+			return exitConfig
+		}
+	}
+	logger = newLogger(logOut, cfg.LogLevel, cfg.LogJSON)
+	if *printCfg {
+		err = printConfig(os.Stdout, cfg)
+		if err != nil {
+			log.Print(err)
+			return exitFailure
+		}
+		return exitSuccess
+	}
+	// Use a dedicated client with a timeout, so a hung IP lookup can't stall
+	// the announce loop. It gets its own transport, so the IP lookups are not
+	// affected by the skyd TLS setup below.
+	ipClient := &http.Client{
+		Timeout:   cfg.IPTimeout,
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	if cfg.SkydTLS {
+		// Both SkyDB and the skyd client use the default transport.
+		t, err := skydTLSTransport(http.DefaultTransport.(*http.Transport), cfg.SkydAddress, cfg.SkydRootCAs)
+		if err != nil {
+			log.Print(errors.AddContext(err, "failed to set up tls to skyd"))
+			return exitConfig
+		}
+		http.DefaultTransport = t
+	}
+	sk, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	// The skylinks only depend on the entropy and the tweaks, so we don't
+	// need skyd in order to print them.
+	if *printSkylink {
+		for _, tweak := range cfg.Tweaks {
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			fmt.Println(sl.String())
+		}
+		return exitSuccess
+	}
+	clientOpts := client.Options{
+		Address:   cfg.SkydAddress,
+		Password:  cfg.SkydApiPassword,
+		UserAgent: cfg.SkydUserAgent,
+	}
+	db, err := skydb.New(sk, pk, clientOpts)
+	if err != nil {
+		log.Print(errors.AddContext(err, "failed to get skydb instance"))
+		return exitFailure
+	}
+	skyd := client.New(clientOpts)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	getIP := ownIPFunc(cfg, skyd, ipClient, *refreshIP)
+
+	opts := options{
+		output:          *output,
+		check:           *check,
+		raw:             *raw,
+		hex:             *rawHex,
+		watch:           *watchInterval,
+		readBackup:      *readBackup,
+		list:            *listOnly,
+		stale:           *staleOnly,
+		dryRun:          *dry,
+		diffOnly:        *diffOnlyFlag,
+		observe:         *observeOnly,
+		evict:           *evictName,
+		importPath:      *importPath,
+		deregister:      *deregisterSelf,
+		confirm:         *confirm,
+		noSpread:        *noSpread,
+		verifySkylink:   *verifySL,
+		daemon:          *daemon,
+		metricsSnapshot: *metricsSnapshot,
+	}
+	return run(ctx, cfg, db, skyd, pk, getIP, opts)
+}
+
+// run runs the operation selected by the options, announcing by default, with
+// the given config and dependencies. It returns the exit code, see the exit*
+// constants.
+func run(ctx context.Context, cfg config, db skyDB, skyd skydClient, pk crypto.PublicKey, getIP func(context.Context) (string, error), opts options) int {
+	runStart := time.Now()
+	if opts.check {
+		checkCtx, checkCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+		failed := printCheckResults(selfCheck(checkCtx, db, skyd, cfg, pk))
+		checkCancel()
+		if failed > 0 {
+			log.Printf("%d checks failed", failed)
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	if opts.raw {
+		for _, tweak := range cfg.Tweaks {
+			readCtx, readCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			b, rev, err := readRawList(readCtx, db, tweak, cfg.OwnName)
+			readCancel()
+			if err != nil {
+				log.Print(errors.AddContext(err, "failed to read server list"))
+				return exitCode(err)
+			}
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			printRaw(opts.hex, sl.String(), rev, b)
+		}
+		return exitSuccess
+	}
+
+	if opts.stale {
+		for i, tweak := range cfg.Tweaks {
+			readCtx, readCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			list, err := readListOrBackup(readCtx, db, cfg, i, opts.readBackup)
+			readCancel()
+			if err != nil {
+				log.Print(errors.AddContext(err, "failed to get server list"))
+				return exitCode(err)
+			}
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			err = printStale(sl.String(), list, clock(), cfg.StaleAfter)
+			if err != nil {
+				log.Print(err)
+				return exitFailure
+			}
+		}
+		return exitSuccess
+	}
+
+	if opts.watch > 0 {
+		watch(ctx, db, cfg, pk, opts.watch, os.Stdout)
+		return exitSuccess
+	}
+
+	if opts.list {
+		for i, tweak := range cfg.Tweaks {
+			readCtx, readCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			list, err := readListOrBackup(readCtx, db, cfg, i, opts.readBackup)
+			readCancel()
+			if err != nil {
+				log.Print(errors.AddContext(err, "failed to get server list"))
+				return exitCode(err)
+			}
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			err = printList(opts.output, sl.String(), list, cfg.StaleAfter)
+			if err != nil {
+				log.Print(err)
+				return exitFailure
+			}
+		}
+		return exitSuccess
+	}
+
+	// Hosts without NTP can have clocks which are far enough off to get their
+	// own announces pruned, so we can take the time from elsewhere.
+	ts := newTimeSource(cfg)
+	syncClock(ctx, ts)
+
+	if opts.dryRun {
+		for _, tweak := range cfg.Tweaks {
+			dryCtx, dryCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			err := dryRun(dryCtx, db, skyd, cfg, tweak, getIP)
+			dryCancel()
+			if err != nil {
+				log.Print(err)
+				return exitCode(err)
+			}
+		}
+		return exitSuccess
+	}
+
+	if opts.diffOnly {
+		code := exitSuccess
+		for _, tweak := range cfg.Tweaks {
+			diffCtx, diffCancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			changed, err := diffOnly(diffCtx, db, skyd, cfg, tweak, getIP)
+			diffCancel()
+			if err != nil {
+				log.Print(err)
+				return exitCode(err)
+			}
+			if changed {
+				code = exitChanged
+			}
+		}
+		return code
+	}
+
+	m := newMetrics()
+	// The snapshot covers everything that uses the metrics, which is all the
+	// operations below.
+	if opts.metricsSnapshot != "" {
+		defer func() {
+			err := writeMetricsSnapshot(opts.metricsSnapshot, m.snapshot(time.Since(runStart)))
+			if err != nil {
+				logger.Error("failed to write metrics snapshot", "error", err)
+			}
+		}()
+	}
+	if cfg.MetricsAddr != "" {
+		err := serveMetrics(ctx, cfg.MetricsAddr, m)
+		if err != nil {
+			log.Print(errors.AddContext(err, "failed to start metrics server"))
+			return exitFailure
+		}
+	}
+	lc := newListCache()
+	wh := newWebhook(cfg.WebhookURL, &http.Client{})
+	st := newStatus(cfg.StaleAfter)
+	if cfg.StatusAddr != "" {
+		err := serveStatus(ctx, cfg.StatusAddr, cfg.StatusToken, st)
+		if err != nil {
+			log.Print(errors.AddContext(err, "failed to start status server"))
+			return exitFailure
+		}
+	}
+
+	if opts.observe {
+		observe(ctx, db, cfg, pk, m, st)
+		logger.Info("received a shutdown signal, exiting")
+		return exitSuccess
+	}
+
+	if opts.evict != "" {
+		if !opts.confirm {
+			log.Print("-evict removes other servers from the list, pass -yes in order to confirm")
+			return exitConfig
+		}
+		failed := 0
+		for _, tweak := range cfg.Tweaks {
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			removed, err := evict(ctx, db, cfg, tweak, opts.evict, m)
+			if errors.Contains(err, context.Canceled) {
+				log.Print("received a shutdown signal, exiting")
+				return exitFailure
+			}
+			if errors.Contains(err, ErrAuthFailed) {
+				log.Print(err)
+				return exitAuth
+			}
+			if err != nil {
+				logger.Error("failed to evict", "skylink", sl.String(), "name", opts.evict, "error", err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: removed %d entries named %s\n", sl.String(), removed, opts.evict)
+		}
+		if failed > 0 {
+			log.Printf("failed to evict from %d out of %d lists", failed, len(cfg.Tweaks))
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	if opts.importPath != "" {
+		servers, err := readImportFile(opts.importPath, cfg.TrustedKeys)
+		if err != nil {
+			log.Print(err)
+			return exitConfig
+		}
+		failed := 0
+		for _, tweak := range cfg.Tweaks {
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			imported, err := importServers(ctx, db, cfg, tweak, servers, m)
+			if errors.Contains(err, context.Canceled) {
+				log.Print("received a shutdown signal, exiting")
+				return exitFailure
+			}
+			if errors.Contains(err, ErrAuthFailed) {
+				log.Print(err)
+				return exitAuth
+			}
+			if err != nil {
+				logger.Error("failed to import", "skylink", sl.String(), "error", err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: imported %d servers\n", sl.String(), imported)
+		}
+		if failed > 0 {
+			log.Printf("failed to import into %d out of %d lists", failed, len(cfg.Tweaks))
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	if opts.deregister {
+		failed := 0
+		for _, tweak := range cfg.Tweaks {
+			err := deregister(ctx, db, cfg, tweak, m)
+			if errors.Contains(err, context.Canceled) {
+				log.Print("received a shutdown signal, exiting")
+				return exitFailure
+			}
+			if errors.Contains(err, ErrAuthFailed) {
+				log.Print(err)
+				return exitAuth
+			}
+			if err != nil {
+				sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+				logger.Error("failed to deregister", "skylink", sl.String(), "error", err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			log.Printf("failed to deregister from %d out of %d lists", failed, len(cfg.Tweaks))
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	// Many servers run this tool on the same schedule, so we delay our first
+	// announce by a random amount of time in order to stagger their writes.
+	if !opts.noSpread && cfg.Spread > 0 {
+		d := spreadDuration(cfg.Spread)
+		logger.Info("delaying the first announce", "delay", d.Round(time.Millisecond))
+		if !sleep(ctx, d) {
+			log.Print("received a shutdown signal, exiting")
+			return exitFailure
+		}
+	}
+
+	// We only write the skylinks to the skylink file once we've successfully
+	// announced to all lists.
+	var skylinks []string
+	for _, tweak := range cfg.Tweaks {
+		sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+		skylinks = append(skylinks, sl.String())
+	}
+
+	if !opts.daemon {
+		failures, err := announceAll(ctx, db, skyd, cfg, pk, getIP, m, lc, st, wh, opts.output, opts.verifySkylink)
+		if errors.Contains(err, context.Canceled) {
+			log.Print("received a shutdown signal, exiting")
+			return exitFailure
+		}
+		if err != nil {
+			log.Print(err)
+			return exitCode(err)
+		}
+		if len(failures) > 0 {
+			log.Printf("failed to announce to %d out of %d lists", len(failures), len(cfg.Tweaks))
+			return exitCode(errors.Compose(failures...))
+		}
+		if cfg.SkylinkFile != "" {
+			err = writeSkylinkFile(cfg.SkylinkFile, skylinks)
+			if err != nil {
+				log.Print(err)
+				return exitFailure
+			}
+		}
+		return exitSuccess
+	}
+
+	// In daemon mode we re-announce on a jittered interval, measured from the
+	// start of each cycle, and we keep going after failed cycles. The jitter
+	// keeps servers which were started together from writing together.
+	for {
+		start := time.Now()
+		syncClock(ctx, ts)
+		failures, err := announceAll(ctx, db, skyd, cfg, pk, getIP, m, lc, st, wh, opts.output, opts.verifySkylink)
+		if err != nil && !errors.Contains(err, context.Canceled) {
+			log.Print(err)
+			return exitCode(err)
+		}
+		if len(failures) > 0 {
+			logger.Error("failed to announce to some lists", "failed", len(failures), "lists", len(cfg.Tweaks))
+		}
+		if len(failures) == 0 && err == nil && cfg.SkylinkFile != "" {
+			err = writeSkylinkFile(cfg.SkylinkFile, skylinks)
+			if err != nil {
+				logger.Error("failed to write skylink file", "path", cfg.SkylinkFile, "error", err)
+			}
+		}
+		if !sleep(ctx, time.Until(start.Add(jitteredInterval(cfg.Interval, cfg.IntervalJitter)))) {
+			logger.Info("received a shutdown signal, exiting")
+			return exitSuccess
+		}
+	}
 }
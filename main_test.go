@@ -0,0 +1,1909 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ro-tex/skydb"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// TestMain silences the logs of the tool, so they don't drown the output of
+// the tests.
+func TestMain(m *testing.M) {
+	logger = newLogger(io.Discard, slog.LevelError, false)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// TestFakeDBRoundTrip verifies that a list we put into the fake SkyDB can be
+// read back, including its revision.
+func TestFakeDBRoundTrip(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	ctx := context.Background()
+	list := []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+	}
+	err := putServerList(ctx, db, list, testTweak, 1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rev, err := getServerList(ctx, db, testTweak, cfg.OwnName, cfg.TrustedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 1 || len(got) != 2 || got[0].Name != "a.siasky.dev" || got[1].IP != "2.2.2.2" {
+		t.Fatalf("unexpected list %v at revision %d", got, rev)
+	}
+	// The registry rejects writes which don't increase the revision.
+	err = putServerList(ctx, db, list, testTweak, 1, cfg)
+	if err == nil {
+		t.Fatal("expected a write at the same revision to fail")
+	}
+}
+
+// TestSuccessCheck verifies that the success check passes when our fresh
+// record is on the stored list and fails when it's missing or outdated.
+func TestSuccessCheck(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	cfg.CheckPolls = 1
+	own := server{Name: cfg.OwnName, IP: "1.1.1.1", LastAnnounce: testTime}
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime}
+	outdated := own
+	outdated.LastAnnounce = testTime.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		stored  []server
+		success bool
+	}{
+		{"fresh", []server{other, own}, true},
+		{"missing", []server{other}, false},
+		{"outdated", []server{other, outdated}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			db.storeList(t, testTweak, tt.stored)
+			err := pollSuccess(context.Background(), db, cfg, testTweak, []server{other, own}, logger)
+			if (err == nil) != tt.success {
+				t.Fatalf("expected success %t, got error %v", tt.success, err)
+			}
+		})
+	}
+}
+
+// TestAnnounceRetriesFailedRead verifies that announce retries after a failed
+// read and that the list it eventually writes contains our record next to the
+// existing ones.
+func TestAnnounceRetriesFailedRead(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}
+	db.storeList(t, testTweak, []server{other})
+	db.onRead = func(n int) error {
+		if n == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	m := newMetrics()
+	list, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", m.attempts)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(list) != 2 || len(stored) != 2 {
+		t.Fatalf("expected 2 servers, wrote %v and stored %v", list, stored)
+	}
+	if i := ownRecordIndex(stored, cfg.OwnName, cfg.NodeID); i < 0 || stored[i].IP != "1.1.1.1" {
+		t.Fatalf("our record is missing from %v", stored)
+	}
+}
+
+// TestGetOwnIP verifies that we accept IPv4 and IPv6 addresses from the IP
+// provider, in their canonical form, and reject anything else.
+func TestGetOwnIP(t *testing.T) {
+	tests := []struct {
+		body  string
+		ip    string
+		valid bool
+	}{
+		{"1.2.3.4", "1.2.3.4", true},
+		{"2001:0db8:0000:0000:0000:0000:0000:0001\n", "2001:db8::1", true},
+		{"<html>not an ip</html>", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, tt.body)
+		}))
+		ip, err := getOwnIP(context.Background(), srv.Client(), srv.URL)
+		srv.Close()
+		if (err == nil) != tt.valid || ip != tt.ip {
+			t.Fatalf("%q: expected %q and valid %t, got %q and %v", tt.body, tt.ip, tt.valid, ip, err)
+		}
+	}
+
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_IPV6", "true")
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.IPProviders) == 0 || cfg.IPProviders[0] != ipifyV6URL {
+		t.Fatalf("expected the IPv6 providers, got %v", cfg.IPProviders)
+	}
+}
+
+// TestGetOwnIPEmptyResponse verifies that a provider hiccup which returns no
+// IP is reported as such and that the surrounding whitespace of a valid
+// response is ignored.
+func TestGetOwnIPEmptyResponse(t *testing.T) {
+	tests := []struct {
+		body string
+		ip   string
+	}{
+		{"", ""},
+		{" \n\t\r\n", ""},
+		{"1.2.3.4\n", "1.2.3.4"},
+		{"  1.2.3.4\r\n", "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, tt.body)
+		}))
+		ip, err := getOwnIP(context.Background(), srv.Client(), srv.URL)
+		srv.Close()
+		if ip != tt.ip {
+			t.Fatalf("%q: expected %q, got %q", tt.body, tt.ip, ip)
+		}
+		if tt.ip == "" && (err == nil || !strings.Contains(err.Error(), "empty response")) {
+			t.Fatalf("%q: expected an empty response error, got %v", tt.body, err)
+		}
+		if tt.ip != "" && err != nil {
+			t.Fatalf("%q: unexpected error %v", tt.body, err)
+		}
+	}
+}
+
+// TestGetOwnIPWithPort verifies that we accept IPs with a port attached and
+// only keep the IP.
+func TestGetOwnIPWithPort(t *testing.T) {
+	tests := []struct {
+		body string
+		ip   string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"1.2.3.4:8080", "1.2.3.4"},
+		{"[::1]:443", "::1"},
+		{" 1.2.3.4:8080\n", "1.2.3.4"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, tt.body)
+		}))
+		ip, err := getOwnIP(context.Background(), srv.Client(), srv.URL)
+		srv.Close()
+		if err != nil || ip != tt.ip {
+			t.Fatalf("%q: expected %q, got %q and %v", tt.body, tt.ip, ip, err)
+		}
+	}
+	for _, s := range []string{"host:8080", "1.2.3.4/24", "1.2.3.4 proxy"} {
+		if ip := parseHostIP(s); ip != nil {
+			t.Fatalf("%q: expected no ip, got %v", s, ip)
+		}
+	}
+}
+
+// TestGetOwnIPClient verifies that getOwnIP queries the given endpoint with
+// the given client, so a hung provider can't stall the announce.
+func TestGetOwnIPClient(t *testing.T) {
+	var queried bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried = true
+		io.WriteString(w, "5.6.7.8")
+	}))
+	defer srv.Close()
+	ip, err := getOwnIP(context.Background(), srv.Client(), srv.URL)
+	if err != nil || ip != "5.6.7.8" {
+		t.Fatalf("expected 5.6.7.8, got %q and %v", ip, err)
+	}
+	if !queried {
+		t.Fatal("expected the request to reach the endpoint")
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if _, err = getOwnIP(context.Background(), failing.Client(), failing.URL); err == nil {
+		t.Fatal("expected an error status to fail")
+	}
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	c := hung.Client()
+	c.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err = getOwnIP(context.Background(), c, hung.URL); err == nil {
+		t.Fatal("expected a hung provider to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the client timeout to apply, took %v", elapsed)
+	}
+}
+
+// TestRetriesStopOnCancel verifies that cancelling the context interrupts the
+// backoff between attempts and that a write which already started completes.
+func TestRetriesStopOnCancel(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.BackoffBase = time.Hour
+	cfg.BackoffMax = time.Hour
+	cfg.MaxAttempts = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	start := time.Now()
+	err := withRetries(ctx, cfg, newMetrics(), func(context.Context, *slog.Logger) error {
+		attempts++
+		time.AfterFunc(50*time.Millisecond, cancel)
+		return errors.New("connection refused")
+	})
+	if !errors.Contains(err, context.Canceled) {
+		t.Fatalf("expected the retries to be cancelled, got %v", err)
+	}
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Fatalf("expected a prompt return after 1 attempt, got %d attempts after %v", attempts, time.Since(start))
+	}
+
+	// A write in flight survives the cancellation.
+	db := newFakeDB()
+	ctx, cancel = context.WithCancel(context.Background())
+	db.onWrite = func(int) error {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	writeCtx, cancelWrite := writeContext(ctx)
+	defer cancelWrite()
+	err = putServerList(writeCtx, db, []server{{Name: cfg.OwnName, LastAnnounce: time.Now()}}, testTweak, 1, cfg)
+	if err != nil {
+		t.Fatalf("expected the write to complete, got %v", err)
+	}
+	if _, rev := db.storedList(t, testTweak); rev != 1 {
+		t.Fatalf("expected the list to be stored, got revision %d", rev)
+	}
+}
+
+// TestRevisionConflict verifies that a write losing a revision race fails with
+// ErrRevisionConflict and that announce retries right away, keeping the record
+// the other server wrote.
+func TestRevisionConflict(t *testing.T) {
+	cfg := testConfig(t)
+	// A backoff would make the test time out, so it shows we don't back off.
+	cfg.BackoffBase = time.Hour
+	cfg.BackoffMax = time.Hour
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{})
+	err := putServerList(context.Background(), db, []server{{Name: cfg.OwnName, LastAnnounce: time.Now()}}, testTweak, 1, cfg)
+	if !errors.Contains(err, ErrRevisionConflict) {
+		t.Fatalf("expected ErrRevisionConflict, got %v", err)
+	}
+
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}
+	db.onWrite = func(n int) error {
+		if n == 2 {
+			db.storeList(t, testTweak, []server{other})
+		}
+		return nil
+	}
+	m := newMetrics()
+	_, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", m.attempts)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 2 || ownRecordIndex(stored, other.Name, "") < 0 || ownRecordIndex(stored, cfg.OwnName, "") < 0 {
+		t.Fatalf("expected both records, got %v", stored)
+	}
+}
+
+// TestBackoffDuration verifies that the backoff doubles from the base with
+// each attempt until it hits the cap and that we wait for a random time between
+// half of the backoff and all of it.
+func TestBackoffDuration(t *testing.T) {
+	base, maxBackoff := time.Second, time.Minute
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		shortest, longest := tt.want, time.Duration(0)
+		for i := 0; i < 100; i++ {
+			d := backoffDuration(tt.attempt, base, maxBackoff)
+			if d < tt.want/2 || d > tt.want {
+				t.Fatalf("attempt %d: expected a backoff between %v and %v, got %v", tt.attempt, tt.want/2, tt.want, d)
+			}
+			if d < shortest {
+				shortest = d
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		if shortest > tt.want*3/4 || longest < tt.want*3/4 {
+			t.Fatalf("attempt %d: expected the backoffs to spread between %v and %v, got %v to %v", tt.attempt, tt.want/2, tt.want, shortest, longest)
+		}
+	}
+}
+
+// TestParseOwnName verifies that we announce our name in its canonical form and
+// reject names which aren't plain hosts.
+func TestParseOwnName(t *testing.T) {
+	tests := []struct {
+		in    string
+		name  string
+		port  int
+		valid bool
+	}{
+		{"dev1.siasky.dev", "dev1.siasky.dev", 0, true},
+		{"https://dev1.siasky.dev/", "dev1.siasky.dev", 0, true},
+		{"dev1.siasky.dev:443", "dev1.siasky.dev", 0, true},
+		{"http://dev1.siasky.dev:80", "dev1.siasky.dev", 0, true},
+		{"https://dev1.siasky.dev:9980", "dev1.siasky.dev", 9980, true},
+		{"https://dev1.siasky.dev/path", "", 0, false},
+		{"https://dev1.siasky.dev?query=1", "", 0, false},
+		{"dev1.siasky.dev:99999", "", 0, false},
+		{"not a host", "", 0, false},
+	}
+	for _, tt := range tests {
+		name, port, err := parseOwnName(tt.in)
+		if (err == nil) != tt.valid || name != tt.name || port != tt.port {
+			t.Fatalf("%q: expected %q:%d and valid %t, got %q:%d and %v", tt.in, tt.name, tt.port, tt.valid, name, port, err)
+		}
+	}
+
+	setTestEnv(t)
+	t.Setenv("SKYNET_SERVER_API", "https://dev1.siasky.dev/path")
+	if _, err := getConfig(); !errors.Contains(err, ErrInvalidOwnName) {
+		t.Fatalf("expected ErrInvalidOwnName, got %v", err)
+	}
+}
+
+// TestDedupServers verifies that reading a list collapses the entries of the
+// same server into the one with the most recent announce.
+func TestDedupServers(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime.Add(-time.Hour)},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+		{Name: "a.siasky.dev", IP: "3.3.3.3", LastAnnounce: testTime},
+	})
+	list, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := ownRecordIndex(list, "a.siasky.dev", ""); len(list) != 2 || i < 0 || list[i].IP != "3.3.3.3" {
+		t.Fatalf("expected the newer entry of a.siasky.dev to win, got %v", list)
+	}
+}
+
+// TestHealthField verifies that our record reflects whether skyd is ready and
+// that failing to reach skyd marks us unhealthy without failing the announce.
+func TestHealthField(t *testing.T) {
+	cfg := testConfig(t)
+	tests := []struct {
+		name    string
+		skyd    *fakeSkyd
+		healthy bool
+	}{
+		{"ready", newFakeSkyd(), true},
+		{"not ready", &fakeSkyd{version: "1.5.10"}, false},
+		{"unreachable", &fakeSkyd{err: errors.New("connection refused")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			_, err := announce(context.Background(), db, tt.skyd, cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := db.storedList(t, testTweak)
+			if len(stored) != 1 || stored[0].Healthy != tt.healthy {
+				t.Fatalf("expected healthy %t, got %v", tt.healthy, stored)
+			}
+		})
+	}
+}
+
+// TestDiscoverIPFallback verifies that we fall back to the next IP provider
+// when one fails or responds with garbage.
+func TestDiscoverIPFallback(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "rate limited")
+	}))
+	defer garbage.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, " 9.9.9.9\n")
+	}))
+	defer up.Close()
+
+	ip, err := discoverIP(context.Background(), http.DefaultClient, []string{down.URL, garbage.URL, up.URL})
+	if err != nil || ip != "9.9.9.9" {
+		t.Fatalf("expected 9.9.9.9, got %q and %v", ip, err)
+	}
+	if _, err = discoverIP(context.Background(), http.DefaultClient, []string{down.URL, garbage.URL}); err == nil {
+		t.Fatal("expected all providers to fail")
+	}
+}
+
+// TestEntropyAndTweakLength verifies that the entropy and the tweak must be
+// exactly 32 bytes.
+func TestEntropyAndTweakLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes int
+		valid bool
+	}{
+		{"too short", 16, false},
+		{"valid", 32, true},
+		{"too long", 33, false},
+	}
+	for _, tt := range tests {
+		value := hex.EncodeToString(make([]byte, tt.bytes))
+		setTestEnv(t)
+		t.Setenv("SERVERLIST_ENTROPY", value)
+		if _, err := getConfig(); (err == nil) != tt.valid || (!tt.valid && !errors.Contains(err, ErrInvalidEntropy)) {
+			t.Fatalf("entropy %s: expected valid %t, got %v", tt.name, tt.valid, err)
+		}
+		setTestEnv(t)
+		t.Setenv("SERVERLIST_TWEAK", value)
+		if _, err := getConfig(); (err == nil) != tt.valid || (!tt.valid && !errors.Contains(err, ErrInvalidTweak)) {
+			t.Fatalf("tweak %s: expected valid %t, got %v", tt.name, tt.valid, err)
+		}
+	}
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_ENTROPY", "not hex")
+	if _, err := getConfig(); !errors.Contains(err, ErrInvalidEntropy) {
+		t.Fatalf("expected ErrInvalidEntropy, got %v", err)
+	}
+}
+
+// TestMissingTweak is a regression test for getConfig checking the name
+// instead of the tweak, which put servers without a tweak on the zero-tweak
+// list.
+func TestMissingTweak(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_TWEAK", "")
+	if os.Getenv("SKYNET_SERVER_API") == "" {
+		t.Fatal("expected SKYNET_SERVER_API to be set")
+	}
+	if _, err := getConfig(); !errors.Contains(err, ErrMissingTweak) {
+		t.Fatalf("expected ErrMissingTweak, got %v", err)
+	}
+}
+
+// TestAttemptTimeout verifies that a hung SkyDB call abandons the attempt at
+// its timeout and that we retry.
+func TestAttemptTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AttemptTimeout = 50 * time.Millisecond
+	db := newFakeDB()
+	release := make(chan struct{})
+	defer close(release)
+	db.onRead = func(n int) error {
+		if n == 1 {
+			<-release
+		}
+		return nil
+	}
+	m := newMetrics()
+	start := time.Now()
+	_, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.attempts != 2 {
+		t.Fatalf("expected the hung attempt to be retried, got %d attempts", m.attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the hung attempt to be abandoned, took %v", elapsed)
+	}
+}
+
+// TestUnmarshalServerList verifies that we read both the versioned envelope and
+// the legacy bare array and that we reject stored lists which would decode into
+// an empty list by accident.
+func TestUnmarshalServerList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		servers int
+		valid   bool
+	}{
+		{"legacy", `[{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"2022-06-01T12:00:00Z"}]`, 1, true},
+		{"legacy empty", `[]`, 0, true},
+		{"v1", `{"version":1,"servers":[{"name":"a.siasky.dev"},{"name":"b.siasky.dev"}]}`, 2, true},
+		{"v1 empty", `{"version":1,"servers":[]}`, 0, true},
+		{"future version", `{"version":2,"servers":[]}`, 0, false},
+		{"null", `null`, 0, false},
+		{"empty object", `{}`, 0, false},
+		{"null servers", `{"version":1,"servers":null}`, 0, false},
+		{"garbage", `not json`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := unmarshalServerList([]byte(tt.data))
+			if (err == nil) != tt.valid {
+				t.Fatalf("expected valid %t, got error %v", tt.valid, err)
+			}
+			if len(list) != tt.servers {
+				t.Fatalf("expected %d servers, got %v", tt.servers, list)
+			}
+		})
+	}
+}
+
+// TestLegacyListUpgrade verifies that announcing to a legacy list writes it
+// back in the versioned format, keeping its servers.
+func TestLegacyListUpgrade(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	legacy := `[{"name":"other.siasky.dev","ip":"2.2.2.2","last_announce":"` + time.Now().UTC().Format(time.RFC3339) + `"}]`
+	db.storeRaw(testTweak, []byte(legacy))
+	_, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	data := db.entries[testTweak].data
+	db.mu.Unlock()
+	var envelope serverList
+	err = json.Unmarshal(data, &envelope)
+	if err != nil {
+		t.Fatalf("the list wasn't upgraded: %v", err)
+	}
+	if envelope.Version != listVersion || len(envelope.Servers) != 2 {
+		t.Fatalf("unexpected upgraded list %s", data)
+	}
+}
+
+// TestMaxServers verifies that putServerList refuses to write lists which
+// exceed the maximum number of servers, that we don't retry such a write, and
+// that trimming drops the servers with the oldest announces instead.
+func TestMaxServers(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxServers = 2
+	list := []server{
+		{Name: "a.siasky.dev", LastAnnounce: testTime.Add(-3 * time.Hour)},
+		{Name: "b.siasky.dev", LastAnnounce: testTime.Add(-time.Hour)},
+		{Name: "c.siasky.dev", LastAnnounce: testTime.Add(-2 * time.Hour)},
+	}
+	db := newFakeDB()
+	err := putServerList(context.Background(), db, list, testTweak, 1, cfg)
+	if !errors.Contains(err, ErrTooManyServers) {
+		t.Fatalf("expected ErrTooManyServers, got %v", err)
+	}
+	if db.writeCount() != 0 {
+		t.Fatal("the list was written")
+	}
+
+	// Announcing doesn't retry a write which got refused.
+	now := time.Now()
+	db.storeList(t, testTweak, []server{
+		{Name: "a.siasky.dev", LastAnnounce: now},
+		{Name: "b.siasky.dev", LastAnnounce: now},
+	})
+	m := newMetrics()
+	_, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+	if !errors.Contains(err, ErrTooManyServers) {
+		t.Fatalf("expected ErrTooManyServers, got %v", err)
+	}
+	if m.attempts != 1 {
+		t.Fatalf("expected a single attempt, got %d", m.attempts)
+	}
+
+	trimmed := trimServers(list, cfg.MaxServers)
+	if len(trimmed) != 2 || trimmed[0].Name != "b.siasky.dev" || trimmed[1].Name != "c.siasky.dev" {
+		t.Fatalf("expected the oldest server to be trimmed, got %v", trimmed)
+	}
+	cfg.Prune = false
+	cfg.TrimServers = true
+	if len(pruneServers(list, cfg)) != 2 {
+		t.Fatal("expected pruneServers to trim the list")
+	}
+}
+
+// TestListErrorsNameServer verifies that the errors of reading and writing the
+// list name the server they happened on.
+func TestListErrorsNameServer(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.onRead = func(int) error { return errors.New("connection refused") }
+	db.onWrite = func(int) error { return errors.New("connection refused") }
+	_, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, cfg.TrustedKeys)
+	if err == nil || !strings.Contains(err.Error(), cfg.OwnName) {
+		t.Fatalf("expected the read error to name %s, got %v", cfg.OwnName, err)
+	}
+	err = putServerList(context.Background(), db, []server{}, testTweak, 1, cfg)
+	if err == nil || !strings.Contains(err.Error(), cfg.OwnName) {
+		t.Fatalf("expected the write error to name %s, got %v", cfg.OwnName, err)
+	}
+}
+
+// TestConfiguredIPSkipsResolve verifies that a configured IP takes precedence
+// over resolving our name.
+func TestConfiguredIPSkipsResolve(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.OwnName = "localhost"
+	cfg.ResolveName = true
+	cfg.IP = "1.2.3.4"
+	list, _, err := updateOwnRecord(context.Background(), nil, cfg, staticIP(cfg.IP), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].IP != cfg.IP || len(list[0].IPs) != 0 {
+		t.Fatalf("expected only the configured IP, got %v", list)
+	}
+}
+
+// TestAnnounceRecoversPanic verifies that a panic of SkyDB fails the attempt
+// instead of crashing the tool and that we retry it.
+func TestAnnounceRecoversPanic(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.onRead = func(n int) error {
+		if n == 1 {
+			panic("boom")
+		}
+		return nil
+	}
+	m := newMetrics()
+	_, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", m.attempts)
+	}
+	err = withContext(context.Background(), func() { panic("boom") })
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
+
+// TestOwnNameEnv verifies that we read our names from SKYNET_SERVER_API and
+// fall back to SERVER_DOMAIN and PORTAL_DOMAIN.
+func TestOwnNameEnv(t *testing.T) {
+	tests := []struct {
+		api, server, portal string
+		name                string
+		aliases             int
+	}{
+		{"https://dev1.siasky.dev,dev2.siasky.dev", "server.siasky.dev", "portal.siasky.dev", "dev1.siasky.dev", 1},
+		{"", "server.siasky.dev", "portal.siasky.dev", "server.siasky.dev", 0},
+		{"", "", "portal.siasky.dev", "portal.siasky.dev", 0},
+	}
+	for _, tt := range tests {
+		setTestEnv(t)
+		t.Setenv("SKYNET_SERVER_API", tt.api)
+		t.Setenv("SERVER_DOMAIN", tt.server)
+		t.Setenv("PORTAL_DOMAIN", tt.portal)
+		cfg, err := getConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.OwnName != tt.name || len(cfg.AliasNames) != tt.aliases {
+			t.Fatalf("expected %s with %d aliases, got %s with %v", tt.name, tt.aliases, cfg.OwnName, cfg.AliasNames)
+		}
+	}
+	t.Setenv("SKYNET_SERVER_API", "")
+	t.Setenv("SERVER_DOMAIN", "")
+	t.Setenv("PORTAL_DOMAIN", "")
+	_, err := getConfig()
+	if !errors.Contains(err, ErrMissingOwnName) {
+		t.Fatalf("expected ErrMissingOwnName, got %v", err)
+	}
+}
+
+// TestAnnounceAliases verifies that we announce a record for each of our names
+// and that the success check requires all of them.
+func TestAnnounceAliases(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AliasNames = []string{"dev2.siasky.dev"}
+	cfg.CheckPolls = 1
+	db := newFakeDB()
+	wrote, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 2 || ownRecordIndex(stored, "dev1.siasky.dev", "") < 0 || ownRecordIndex(stored, "dev2.siasky.dev", "") < 0 {
+		t.Fatalf("expected records for both names, got %v", stored)
+	}
+	if stored[0].IP != stored[1].IP || !stored[0].LastAnnounce.Equal(stored[1].LastAnnounce) {
+		t.Fatalf("expected both records to share the IP and announce time, got %v", stored)
+	}
+
+	// The check fails when the record of the alias is missing.
+	i := ownRecordIndex(stored, "dev2.siasky.dev", "")
+	db.storeList(t, testTweak, append(stored[:i:i], stored[i+1:]...))
+	err = pollSuccess(context.Background(), db, cfg, testTweak, wrote, logger)
+	if err == nil || !strings.Contains(err.Error(), "dev2.siasky.dev") {
+		t.Fatalf("expected the check to fail for dev2.siasky.dev, got %v", err)
+	}
+}
+
+// TestPollSuccessDeadline verifies that polling for success gives up at the
+// deadline of its context instead of sleeping past it.
+func TestPollSuccessDeadline(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CheckPolls = 10
+	cfg.CheckPollDelay = time.Hour
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{{Name: "other.siasky.dev", LastAnnounce: time.Now()}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := pollSuccess(ctx, db, cfg, testTweak, nil, logger)
+	if err == nil {
+		t.Fatal("expected the check to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up at the deadline, took %v", elapsed)
+	}
+}
+
+// TestVersionField verifies that our record carries skyd's version and that we
+// leave it empty when skyd can't tell us.
+func TestVersionField(t *testing.T) {
+	cfg := testConfig(t)
+	skyd := newFakeSkyd()
+	skyd.version = "1.6.0"
+	list, _, err := updateOwnRecord(context.Background(), nil, cfg, staticIP("1.1.1.1"), skyd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Version != "1.6.0" {
+		t.Fatalf("expected version 1.6.0, got %v", list)
+	}
+	skyd.err = errors.New("connection refused")
+	list, _, err = updateOwnRecord(context.Background(), list, cfg, staticIP("1.1.1.1"), skyd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Version != "" || list[0].LastError == "" {
+		t.Fatalf("expected no version and the error on the record, got %v", list)
+	}
+}
+
+// TestDeregister verifies that deregistering removes our record and the
+// records of our aliases while keeping the other servers, that it's a no-op
+// when we're not on the list and that it retries after a revision conflict.
+func TestDeregister(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AliasNames = []string{"alias.siasky.dev"}
+	now := time.Now()
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: now}
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{
+		{Name: cfg.OwnName, IP: "1.1.1.1", LastAnnounce: now},
+		{Name: "alias.siasky.dev", IP: "1.1.1.1", LastAnnounce: now},
+		other,
+	})
+	db.onWrite = func(n int) error {
+		if n == 1 {
+			db.storeList(t, testTweak, []server{
+				{Name: cfg.OwnName, IP: "1.1.1.1", LastAnnounce: now},
+				{Name: "alias.siasky.dev", IP: "1.1.1.1", LastAnnounce: now},
+				other,
+			})
+		}
+		return nil
+	}
+	m := newMetrics()
+	if err := deregister(context.Background(), db, cfg, testTweak, m); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 1 || stored[0].Name != other.Name {
+		t.Fatalf("expected only %s to remain, got %v", other.Name, stored)
+	}
+	if m.attempts != 2 {
+		t.Fatalf("expected a retry after the conflict, got %d attempts", m.attempts)
+	}
+
+	writes := db.writeCount()
+	if err := deregister(context.Background(), db, cfg, testTweak, newMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	if db.writeCount() != writes {
+		t.Fatal("expected no write when we're not on the list")
+	}
+}
+
+// TestListNotFound verifies that a list which doesn't exist yet reads as an
+// empty list, even when the not found error comes wrapped, and that announcing
+// creates it.
+func TestListNotFound(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.onRead = func(n int) error {
+		if n == 1 {
+			return errors.AddContext(skydb.ErrNotFound, "registry lookup failed")
+		}
+		return nil
+	}
+	list, rev, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, nil)
+	if err != nil || len(list) != 0 || rev != 0 {
+		t.Fatalf("expected an empty list at revision 0, got %v at %d and %v", list, rev, err)
+	}
+	if _, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if stored, rev := db.storedList(t, testTweak); len(stored) != 1 || rev != 1 {
+		t.Fatalf("expected the list to be created, got %v at revision %d", stored, rev)
+	}
+}
+
+// TestRegion verifies that we announce the configured region and validate it
+// against the allow-list, if there is one.
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		name, region, allowed string
+		valid                 bool
+	}{
+		{"unset", "", "us-east,eu-west", true},
+		{"allowed", "eu-west", "us-east, eu-west", true},
+		{"disallowed", "ap-south", "us-east,eu-west", false},
+		{"no allow-list", "ap-south", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestEnv(t)
+			t.Setenv("SERVERLIST_REGION", tt.region)
+			t.Setenv("SERVERLIST_REGIONS_ALLOWED", tt.allowed)
+			cfg, err := getConfig()
+			if (err == nil) != tt.valid {
+				t.Fatalf("expected valid %t, got %v", tt.valid, err)
+			}
+			if !tt.valid {
+				return
+			}
+			list, _, err := updateOwnRecord(context.Background(), nil, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+			if err != nil || list[0].Region != tt.region {
+				t.Fatalf("expected region %q, got %v and %v", tt.region, list, err)
+			}
+		})
+	}
+	// Lists without the field still read.
+	list, err := unmarshalServerList([]byte(`[{"name":"a.siasky.dev"}]`))
+	if err != nil || list[0].Region != "" {
+		t.Fatalf("expected no region, got %v and %v", list, err)
+	}
+}
+
+// TestSortedList verifies that the same servers in any order are stored as the
+// same bytes, sorted by name and IP.
+func TestSortedList(t *testing.T) {
+	cfg := testConfig(t)
+	a1 := server{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}
+	a2 := server{Name: "a.siasky.dev", IP: "2.2.2.2", ID: "0b2f6c4e-7a1d-4b8e-9c3f-5d6e7f8a9b0c", LastAnnounce: testTime}
+	b := server{Name: "b.siasky.dev", IP: "0.0.0.1", LastAnnounce: testTime}
+	sorted, err := encodeList([]server{a1, a2, b}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsorted, err := encodeList([]server{b, a2, a1}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sorted, unsorted) {
+		t.Fatalf("expected the same bytes, got %s and %s", sorted, unsorted)
+	}
+	list, err := unmarshalServerList(unsorted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].IP != "1.1.1.1" || list[1].IP != "2.2.2.2" || list[2].Name != "b.siasky.dev" {
+		t.Fatalf("expected the list to be sorted, got %v", list)
+	}
+}
+
+// TestSpreadDuration verifies that the delay before the first announce stays
+// within the configured window and that the window defaults to 30s.
+func TestSpreadDuration(t *testing.T) {
+	window := 100 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		if d := spreadDuration(window); d < 0 || d > window {
+			t.Fatalf("expected a delay within %v, got %v", window, d)
+		}
+	}
+	if d := spreadDuration(0); d != 0 {
+		t.Fatalf("expected no delay without a window, got %v", d)
+	}
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil || cfg.Spread != 30*time.Second {
+		t.Fatalf("expected a default window of 30s, got %v and %v", cfg.Spread, err)
+	}
+	t.Setenv("SERVERLIST_SPREAD", "-1s")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected a negative window to be rejected")
+	}
+}
+
+// TestConfigErrors verifies that each misconfiguration results in its own
+// error, so callers can tell them apart.
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, env, value string
+		want             error
+	}{
+		{"missing name", "SKYNET_SERVER_API", "", ErrMissingOwnName},
+		{"invalid name", "SKYNET_SERVER_API", "dev1.siasky.dev/path", ErrInvalidOwnName},
+		{"invalid port", "SKYNET_SERVER_PORT", "0", ErrInvalidPort},
+		{"invalid region", "SERVERLIST_REGION", "mars", ErrInvalidRegion},
+		{"missing entropy", "SERVERLIST_ENTROPY", "", ErrMissingEntropy},
+		{"invalid entropy", "SERVERLIST_ENTROPY", "abc", ErrInvalidEntropy},
+		{"missing tweak", "SERVERLIST_TWEAK", "", ErrMissingTweak},
+		{"invalid tweak", "SERVERLIST_TWEAK", "00", ErrInvalidTweak},
+		{"missing api password", "SIA_API_PASSWORD", "", ErrMissingAPIPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestEnv(t)
+			t.Setenv("SERVERLIST_REGIONS_ALLOWED", "eu-west")
+			t.Setenv(tt.env, tt.value)
+			_, err := getConfig()
+			if !errors.Contains(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// TestForcedIP verifies that a configured IP takes precedence over discovering
+// our IP and that invalid IPs are rejected.
+func TestForcedIP(t *testing.T) {
+	queried := false
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried = true
+		io.WriteString(w, "9.9.9.9")
+	}))
+	defer provider.Close()
+
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_IP", "2001:db8::0001")
+	t.Setenv("SERVERLIST_IP_PROVIDERS", provider.URL)
+	t.Setenv("SERVERLIST_IP_CACHE_TTL", "0s")
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := ownIPFunc(cfg, newFakeSkyd(), provider.Client(), false)(context.Background())
+	if err != nil || ip != "2001:db8::1" || queried {
+		t.Fatalf("expected the configured ip without a query, got %q, %v and queried %t", ip, err, queried)
+	}
+
+	cfg.IP = ""
+	ip, err = ownIPFunc(cfg, newFakeSkyd(), provider.Client(), false)(context.Background())
+	if err != nil || ip != "9.9.9.9" || !queried {
+		t.Fatalf("expected the discovered ip, got %q and %v", ip, err)
+	}
+
+	t.Setenv("SERVERLIST_IP", "1.2.3")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected an invalid ip to be rejected")
+	}
+}
+
+// TestIPChange verifies that updateOwnRecord reports whether our IP changed
+// since our last announce.
+func TestIPChange(t *testing.T) {
+	cfg := testConfig(t)
+	list := []server{{Name: cfg.OwnName, IP: "1.1.1.1", LastAnnounce: testTime}}
+	list, change, err := updateOwnRecord(context.Background(), list, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil || change.changed() || change.Old != "1.1.1.1" {
+		t.Fatalf("expected no change, got %+v and %v", change, err)
+	}
+	list, change, err = updateOwnRecord(context.Background(), list, cfg, staticIP("2.2.2.2"), newFakeSkyd())
+	if err != nil || !change.changed() || change.Old != "1.1.1.1" || change.New != "2.2.2.2" {
+		t.Fatalf("expected a change from 1.1.1.1 to 2.2.2.2, got %+v and %v", change, err)
+	}
+	if list[0].IP != "2.2.2.2" {
+		t.Fatalf("expected the new ip on our record, got %v", list)
+	}
+	// A failed discovery keeps the previous IP and isn't a change.
+	failing := func(context.Context) (string, error) { return "", errors.New("all ip providers failed") }
+	list, change, err = updateOwnRecord(context.Background(), list, cfg, failing, newFakeSkyd())
+	if err != nil || change.changed() || list[0].IP != "2.2.2.2" {
+		t.Fatalf("expected the ip to be kept, got %+v, %v and %v", change, list, err)
+	}
+}
+
+// TestEnsureOwnRecord verifies that the guard before the write catches an
+// update which lost our record.
+func TestEnsureOwnRecord(t *testing.T) {
+	cfg := testConfig(t)
+	other := server{Name: "other.siasky.dev", LastAnnounce: testTime}
+	list, _, err := updateOwnRecord(context.Background(), []server{other}, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ensureOwnRecord(list, cfg.OwnName, cfg.NodeID); err != nil {
+		t.Fatal(err)
+	}
+	// A broken updater which drops our record.
+	broken, _ := removeServer(list, cfg.OwnName, cfg.NodeID)
+	if err = ensureOwnRecord(broken, cfg.OwnName, cfg.NodeID); err == nil {
+		t.Fatal("expected the guard to catch the missing record")
+	}
+}
+
+// TestSuccessWindow verifies that records just inside the success window pass
+// the check and records just outside of it don't, and that the window must be
+// positive.
+func TestSuccessWindow(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_SUCCESS_WINDOW", "10m")
+	cfg, err := getConfig()
+	if err != nil || cfg.SuccessWindow != 10*time.Minute {
+		t.Fatalf("expected a window of 10m, got %v and %v", cfg.SuccessWindow, err)
+	}
+	setClock(t, testTime)
+	record := func(age time.Duration) []server {
+		return []server{{Name: cfg.OwnName, LastAnnounce: testTime.Add(-age)}}
+	}
+	if err = checkRecord(record(cfg.SuccessWindow-time.Second), nil, testTweak, cfg.OwnName, "", cfg.SuccessWindow); err != nil {
+		t.Fatalf("expected a record inside the window to pass, got %v", err)
+	}
+	if err = checkRecord(record(cfg.SuccessWindow), nil, testTweak, cfg.OwnName, "", cfg.SuccessWindow); err == nil {
+		t.Fatal("expected a record outside the window to fail")
+	}
+	for _, value := range []string{"0s", "-5m"} {
+		t.Setenv("SERVERLIST_SUCCESS_WINDOW", value)
+		if _, err = getConfig(); err == nil {
+			t.Fatalf("expected %s to be rejected", value)
+		}
+	}
+}
+
+// TestSkylink verifies that the entropy and the tweak always derive the same
+// skylink, which consumers of the list are configured with, and that deriving
+// it needs neither our name nor the API password.
+func TestSkylink(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SKYNET_SERVER_API", "")
+	t.Setenv("SIA_API_PASSWORD", "")
+	cfg, err := getReadOnlyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), cfg.Tweaks[0])
+	if sl.String() != "AQADrvzKkzixb4ZPzMETzSnyzB-o8UdC_fbydh73-93S8g" {
+		t.Fatalf("unexpected skylink %s", sl.String())
+	}
+}
+
+// TestAuthErrorNotRetried verifies that we give up right away when skyd
+// rejects our API password, while we retry transient errors.
+func TestAuthErrorNotRetried(t *testing.T) {
+	cfg := testConfig(t)
+	tests := []struct {
+		name     string
+		err      error
+		attempts uint64
+		class    errorClass
+	}{
+		{"auth", errors.New("[" + skydAuthError + "]"), 1, errAuth},
+		{"transient", errors.New("connection refused"), uint64(cfg.MaxAttempts), errTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			db.onRead = func(int) error { return tt.err }
+			m := newMetrics()
+			_, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+			if err == nil {
+				t.Fatal("expected the announce to fail")
+			}
+			if classifyError(err) != tt.class {
+				t.Fatalf("expected class %v, got %v for %v", tt.class, classifyError(err), err)
+			}
+			if m.attempts != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, m.attempts)
+			}
+		})
+	}
+}
+
+// TestBackoffConfig verifies that the backoff base and cap are read from the
+// env, that invalid values are rejected and that the backoff doubles from the
+// base until it hits the cap.
+func TestBackoffConfig(t *testing.T) {
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BackoffBase != time.Second || cfg.BackoffMax != 3*time.Minute {
+		t.Fatalf("expected the defaults, got %v and %v", cfg.BackoffBase, cfg.BackoffMax)
+	}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{8, 128 * time.Second},
+		{9, 3 * time.Minute},
+		{100, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := backoffDuration(tt.attempt, cfg.BackoffBase, cfg.BackoffMax)
+			if d < tt.want/2 || d > tt.want {
+				t.Fatalf("attempt %d: expected a backoff between %v and %v, got %v", tt.attempt, tt.want/2, tt.want, d)
+			}
+		}
+	}
+
+	t.Setenv("SERVERLIST_BACKOFF_BASE", "100ms")
+	t.Setenv("SERVERLIST_BACKOFF_MAX", "1s")
+	cfg, err = getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BackoffBase != 100*time.Millisecond || cfg.BackoffMax != time.Second {
+		t.Fatalf("expected 100ms and 1s, got %v and %v", cfg.BackoffBase, cfg.BackoffMax)
+	}
+
+	invalid := []struct {
+		base, max string
+	}{
+		{"0s", "1s"},
+		{"-1s", "1s"},
+		{"soon", "1s"},
+		{"1s", "later"},
+		{"2s", "1s"},
+	}
+	for _, tt := range invalid {
+		t.Setenv("SERVERLIST_BACKOFF_BASE", tt.base)
+		t.Setenv("SERVERLIST_BACKOFF_MAX", tt.max)
+		if _, err = getConfig(); err == nil {
+			t.Fatalf("base %s and max %s: expected an error", tt.base, tt.max)
+		}
+	}
+}
+
+// TestReread verifies that re-reading the list right before the write picks up
+// a write another server made after our first read, so we don't lose a
+// revision race, and that without it the same interleaving costs an attempt.
+func TestReread(t *testing.T) {
+	for _, reread := range []bool{true, false} {
+		cfg := testConfig(t)
+		cfg.Reread = reread
+		other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}
+		db := newFakeDB()
+		db.storeList(t, testTweak, []server{})
+		// The other server writes between our first read and our write. With
+		// the re-read, that's before our second read.
+		interleave := func(n int) error {
+			if n == 1 {
+				db.storeList(t, testTweak, []server{other})
+			}
+			return nil
+		}
+		if reread {
+			db.onRead = func(n int) error { return interleave(n - 1) }
+		} else {
+			db.onWrite = interleave
+		}
+		m := newMetrics()
+		_, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attempts := uint64(1)
+		if !reread {
+			attempts = 2
+		}
+		if m.attempts != attempts {
+			t.Fatalf("reread %t: expected %d attempts, got %d", reread, attempts, m.attempts)
+		}
+		stored, _ := db.storedList(t, testTweak)
+		if len(stored) != 2 || ownRecordIndex(stored, other.Name, "") < 0 || ownRecordIndex(stored, cfg.OwnName, "") < 0 {
+			t.Fatalf("reread %t: expected both records, got %v", reread, stored)
+		}
+	}
+}
+
+// TestRawList verifies that the raw read returns the stored bytes and their
+// revision even when they aren't a valid list, which is when we need them.
+func TestRawList(t *testing.T) {
+	db := newFakeDB()
+	garbage := []byte("{\"version\":1,\"servers\":[{\"name\":\x00")
+	db.storeRaw(testTweak, []byte("[]"))
+	db.storeRaw(testTweak, garbage)
+	if _, _, err := getServerList(context.Background(), db, testTweak, "dev1.siasky.dev", nil); err == nil {
+		t.Fatal("expected the list not to parse")
+	}
+	b, rev, err := readRawList(context.Background(), db, testTweak, "dev1.siasky.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, garbage) || rev != 2 {
+		t.Fatalf("expected %q at revision 2, got %q at revision %d", garbage, b, rev)
+	}
+	if _, _, err = readRawList(context.Background(), newFakeDB(), testTweak, "dev1.siasky.dev"); !errors.Contains(err, skydb.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestLabels verifies that labels are parsed from SERVERLIST_LABELS, that
+// malformed ones are rejected and that they survive a round trip through the
+// list, for our record as well as for the records of others.
+func TestLabels(t *testing.T) {
+	labels, err := parseLabels("portal=true, tier = premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["portal"] != "true" || labels["tier"] != "premium" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	for _, s := range []string{"portal", "portal=", "=true", "a=b=c", "a=b,,c=d", "a=b,a=c"} {
+		if _, err = parseLabels(s); err == nil {
+			t.Fatalf("expected %q to be rejected", s)
+		}
+	}
+
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_LABELS", "tier=premium")
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Labels) != 1 || cfg.Labels["tier"] != "premium" {
+		t.Fatalf("unexpected labels %v", cfg.Labels)
+	}
+	t.Setenv("SERVERLIST_LABELS", "tier")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected invalid labels to be rejected")
+	}
+
+	t.Setenv("SERVERLIST_LABELS", "tier=premium")
+	cfg = testConfig(t)
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now(), Labels: map[string]string{"portal": "true"}}
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{other})
+	for i := 0; i < 2; i++ {
+		if _, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, _ := db.storedList(t, testTweak)
+	own, o := ownRecordIndex(stored, cfg.OwnName, ""), ownRecordIndex(stored, other.Name, "")
+	if own < 0 || o < 0 {
+		t.Fatalf("unexpected list %v", stored)
+	}
+	if len(stored[own].Labels) != 1 || stored[own].Labels["tier"] != "premium" {
+		t.Fatalf("unexpected labels of our record %v", stored[own].Labels)
+	}
+	if len(stored[o].Labels) != 1 || stored[o].Labels["portal"] != "true" {
+		t.Fatalf("unexpected labels of %s %v", other.Name, stored[o].Labels)
+	}
+
+	// Without SERVERLIST_LABELS we keep the labels of our record and with an
+	// empty one we clear them.
+	for _, empty := range []bool{false, true} {
+		if empty {
+			t.Setenv("SERVERLIST_LABELS", "")
+		} else {
+			os.Unsetenv("SERVERLIST_LABELS")
+		}
+		cfg = testConfig(t)
+		if _, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+			t.Fatal(err)
+		}
+		stored, _ = db.storedList(t, testTweak)
+		own = ownRecordIndex(stored, cfg.OwnName, "")
+		if kept := stored[own].Labels["tier"] == "premium"; kept == empty {
+			t.Fatalf("empty %t: unexpected labels of our record %v", empty, stored[own].Labels)
+		}
+	}
+}
+
+// TestJitteredInterval verifies that the daemon sleeps within the jitter around
+// the interval, that the sleeps average out to the interval and that the
+// jitter is read from SERVERLIST_INTERVAL_JITTER.
+func TestJitteredInterval(t *testing.T) {
+	interval := time.Minute
+	for _, jitter := range []float64{0, 0.1, 0.5} {
+		lo := interval - time.Duration(float64(interval)*jitter)
+		hi := interval + time.Duration(float64(interval)*jitter)
+		var total time.Duration
+		const n = 10000
+		for i := 0; i < n; i++ {
+			d := jitteredInterval(interval, jitter)
+			if d < lo || d > hi {
+				t.Fatalf("jitter %v: expected a sleep between %v and %v, got %v", jitter, lo, hi, d)
+			}
+			total += d
+		}
+		// The mean of n uniform samples is well within 2% of the interval.
+		if mean := total / n; mean < interval*98/100 || mean > interval*102/100 {
+			t.Fatalf("jitter %v: expected a mean close to %v, got %v", jitter, interval, mean)
+		}
+	}
+
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IntervalJitter != 0.1 {
+		t.Fatalf("expected a default jitter of 0.1, got %v", cfg.IntervalJitter)
+	}
+	t.Setenv("SERVERLIST_INTERVAL_JITTER", "0.25")
+	if cfg, err = getConfig(); err != nil || cfg.IntervalJitter != 0.25 {
+		t.Fatalf("expected a jitter of 0.25, got %v and %v", cfg.IntervalJitter, err)
+	}
+	for _, value := range []string{"-0.1", "1", "lots"} {
+		t.Setenv("SERVERLIST_INTERVAL_JITTER", value)
+		if _, err = getConfig(); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestVerifyRoundTrip verifies that we catch marshalled lists which don't read
+// back into the servers we meant to write.
+func TestVerifyRoundTrip(t *testing.T) {
+	cfg := testConfig(t)
+	list := []server{
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+	}
+	data, err := encodeList(list, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyRoundTrip(data, list); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := marshalServerList([]server{list[0], {Name: "c.siasky.dev", IP: "3.3.3.3", LastAnnounce: testTime}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := marshalServerList(list[:1], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anomalies := map[string][]byte{
+		"truncated":      data[:len(data)/2],
+		"missing server": short,
+		"renamed server": other,
+		"not json":       []byte("null\x00"),
+	}
+	for name, b := range anomalies {
+		if err = verifyRoundTrip(b, list); err == nil {
+			t.Fatalf("%s: expected the round trip check to fail", name)
+		}
+	}
+}
+
+// TestEvict verifies that evict removes every record with the given name,
+// regardless of its age, doesn't write when there's nothing to remove and that
+// run refuses to evict without a confirmation.
+func TestEvict(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Now()
+	list := []server{
+		{Name: "dead.siasky.dev", IP: "2.2.2.2", LastAnnounce: now},
+		{ID: "node-a", Name: "twice.siasky.dev", IP: "3.3.3.3", LastAnnounce: now},
+		{ID: "node-b", Name: "twice.siasky.dev", IP: "4.4.4.4", LastAnnounce: now.Add(-time.Hour)},
+		{Name: "alive.siasky.dev", IP: "5.5.5.5", LastAnnounce: now},
+	}
+	tests := []struct {
+		name    string
+		removed int
+	}{
+		{"dead.siasky.dev", 1},
+		{"missing.siasky.dev", 0},
+		{"twice.siasky.dev", 2},
+	}
+	for _, tt := range tests {
+		db := newFakeDB()
+		db.storeList(t, testTweak, list)
+		removed, err := evict(context.Background(), db, cfg, testTweak, tt.name, newMetrics())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != tt.removed {
+			t.Fatalf("%s: expected %d removed, got %d", tt.name, tt.removed, removed)
+		}
+		if tt.removed == 0 && db.writeCount() != 0 {
+			t.Fatalf("%s: expected no writes, got %d", tt.name, db.writeCount())
+		}
+		stored, _ := db.storedList(t, testTweak)
+		if len(stored) != len(list)-tt.removed || ownRecordIndex(stored, tt.name, "") >= 0 {
+			t.Fatalf("%s: unexpected list %v", tt.name, stored)
+		}
+	}
+
+	db := newFakeDB()
+	db.storeList(t, testTweak, list)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), options{evict: "dead.siasky.dev"}); code != exitConfig {
+		t.Fatalf("expected exit code %d without confirmation, got %d", exitConfig, code)
+	}
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes without confirmation, got %d", db.writeCount())
+	}
+	if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), options{evict: "dead.siasky.dev", confirm: true}); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+	if stored, _ := db.storedList(t, testTweak); len(stored) != len(list)-1 {
+		t.Fatalf("unexpected list %v", stored)
+	}
+}
+
+// TestDiffLists verifies that diffLists tells additions, IP updates,
+// timestamp-only updates and prunes apart, and that dry-run never writes.
+func TestDiffLists(t *testing.T) {
+	old := []server{
+		{Name: "same.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "moved.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+		{Name: "refreshed.siasky.dev", IP: "3.3.3.3", LastAnnounce: testTime},
+		{Name: "pruned.siasky.dev", IP: "4.4.4.4", LastAnnounce: testTime},
+	}
+	next := []server{
+		{Name: "same.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "moved.siasky.dev", IP: "5.5.5.5", LastAnnounce: testTime},
+		{Name: "refreshed.siasky.dev", IP: "3.3.3.3", LastAnnounce: testTime.Add(time.Hour)},
+		{Name: "new.siasky.dev", IP: "6.6.6.6", LastAnnounce: testTime},
+	}
+	added, updated, removed := diffLists(old, next)
+	if len(added) != 1 || added[0].Name != "new.siasky.dev" {
+		t.Fatalf("unexpected additions %v", added)
+	}
+	if len(updated) != 2 || updated[0].IP != "5.5.5.5" || !updated[1].LastAnnounce.Equal(testTime.Add(time.Hour)) {
+		t.Fatalf("unexpected updates %v", updated)
+	}
+	if len(removed) != 1 || removed[0].Name != "pruned.siasky.dev" {
+		t.Fatalf("unexpected removals %v", removed)
+	}
+	if added, updated, removed = diffLists(old, old); len(added)+len(updated)+len(removed) != 0 {
+		t.Fatalf("expected no changes, got %v, %v and %v", added, updated, removed)
+	}
+
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.storeList(t, testTweak, old)
+	if err := dryRun(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1")); err != nil {
+		t.Fatal(err)
+	}
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes, got %d", db.writeCount())
+	}
+}
+
+// TestIPTimeout verifies that a slow IP provider trips the IP timeout, well
+// within the attempt timeout, and that we still announce, without an IP.
+func TestIPTimeout(t *testing.T) {
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IPTimeout != 5*time.Second {
+		t.Fatalf("expected a default of 5s, got %v", cfg.IPTimeout)
+	}
+	t.Setenv("SERVERLIST_IP_TIMEOUT", "0s")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected a zero timeout to be rejected")
+	}
+	t.Setenv("SERVERLIST_IP_TIMEOUT", "50ms")
+	cfg = testConfig(t)
+	if cfg.IPTimeout != 50*time.Millisecond {
+		t.Fatalf("expected 50ms, got %v", cfg.IPTimeout)
+	}
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "1.2.3.4")
+	}))
+	defer slow.Close()
+	defer close(release)
+	cfg.IPProviders = []string{slow.URL}
+	cfg.AttemptTimeout = time.Minute
+	c := slow.Client()
+	c.Timeout = cfg.IPTimeout
+	skyd := newFakeSkyd()
+	db := newFakeDB()
+	start := time.Now()
+	if _, err = announce(context.Background(), db, skyd, cfg, testTweak, ownIPFunc(cfg, skyd, c, false), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the ip timeout to apply, took %v", elapsed)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if i := ownRecordIndex(stored, cfg.OwnName, ""); i < 0 || stored[i].IP != "" {
+		t.Fatalf("expected our record without an ip, got %v", stored)
+	}
+}
+
+// TestRun verifies that run announces us end to end against a SkyDB which
+// accepts our writes, both once and as a daemon.
+func TestRun(t *testing.T) {
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	opts := options{output: outputText, noSpread: true}
+	for i := 1; i <= 2; i++ {
+		if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code != exitSuccess {
+			t.Fatalf("run %d: expected exit code %d, got %d", i, exitSuccess, code)
+		}
+		stored, rev := db.storedList(t, testTweak)
+		if rev != uint64(i) {
+			t.Fatalf("run %d: expected revision %d, got %d", i, i, rev)
+		}
+		if len(stored) != 1 || stored[0].Name != cfg.OwnName || stored[0].IP != "1.1.1.1" {
+			t.Fatalf("run %d: unexpected list %v", i, stored)
+		}
+	}
+
+	// The daemon keeps announcing until it's told to stop.
+	cfg.Interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db = newFakeDB()
+	db.onWrite = func(n int) error {
+		if n == 3 {
+			cancel()
+		}
+		return nil
+	}
+	opts.daemon = true
+	if code := run(ctx, cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+	if db.writeCount() < 3 {
+		t.Fatalf("expected the daemon to keep announcing, got %d writes", db.writeCount())
+	}
+}
+
+// TestLastError verifies that our record reports the problems we ran into
+// while announcing and that a clean announce clears them.
+func TestLastError(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	skyd := newFakeSkyd()
+	ownRecord := func() server {
+		t.Helper()
+		stored, _ := db.storedList(t, testTweak)
+		i := ownRecordIndex(stored, cfg.OwnName, "")
+		if i < 0 {
+			t.Fatalf("expected our record, got %v", stored)
+		}
+		return stored[i]
+	}
+
+	skyd.err = errors.New("skyd is down")
+	if _, err := announce(context.Background(), db, skyd, cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	s := ownRecord()
+	if !strings.Contains(s.LastError, "readiness") || !strings.Contains(s.LastError, "skyd is down") || s.Healthy {
+		t.Fatalf("expected an unhealthy record with the error, got %+v", s)
+	}
+
+	skyd.err = nil
+	if _, err := announce(context.Background(), db, skyd, cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s = ownRecord(); s.LastError != "" || !s.Healthy {
+		t.Fatalf("expected a healthy record without an error, got %+v", s)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "last_error") {
+		t.Fatalf("expected last_error to be omitted, got %s", b)
+	}
+}
+
+// TestIPFromSkyd verifies that we take our IP from skyd's gateway address when
+// configured and that we fall back to the IP providers when skyd can't tell us
+// a public one.
+func TestIPFromSkyd(t *testing.T) {
+	t.Setenv("SERVERLIST_IP_FROM_SKYD", "true")
+	cfg := testConfig(t)
+	if !cfg.IPFromSkyd {
+		t.Fatal("expected SERVERLIST_IP_FROM_SKYD to be set")
+	}
+	queried := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried++
+		io.WriteString(w, "5.6.7.8")
+	}))
+	defer srv.Close()
+	cfg.IPProviders = []string{srv.URL}
+
+	tests := []struct {
+		gateway modules.NetAddress
+		err     error
+		ip      string
+		queried int
+	}{
+		{"8.8.4.4:9981", nil, "8.8.4.4", 0},
+		{"[2001:4860:4860::8844]:9981", nil, "2001:4860:4860::8844", 0},
+		{"192.168.1.2:9981", nil, "5.6.7.8", 1},
+		{"127.0.0.1:9981", nil, "5.6.7.8", 1},
+		{"8.8.4.4:9981", errors.New("skyd is down"), "5.6.7.8", 1},
+	}
+	for _, tt := range tests {
+		queried = 0
+		skyd := newFakeSkyd()
+		skyd.gateway = tt.gateway
+		skyd.err = tt.err
+		ip, err := ownIPFunc(cfg, skyd, srv.Client(), false)(context.Background())
+		if err != nil || ip != tt.ip {
+			t.Fatalf("%s: expected %s, got %q and %v", tt.gateway, tt.ip, ip, err)
+		}
+		if queried != tt.queried {
+			t.Fatalf("%s: expected %d provider queries, got %d", tt.gateway, tt.queried, queried)
+		}
+	}
+}
+
+// TestPrettyList verifies that SERVERLIST_PRETTY stores an indented list which
+// reads back just like a minified one.
+func TestPrettyList(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_PRETTY", "true")
+	cfg, err := getConfig()
+	if err != nil || !cfg.Pretty {
+		t.Fatalf("expected pretty printing to be enabled, got %v", err)
+	}
+	list := []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+	}
+	sizes := make(map[bool]int)
+	for _, pretty := range []bool{false, true} {
+		cfg.Pretty = pretty
+		db := newFakeDB()
+		err = putServerList(context.Background(), db, list, testTweak, 1, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _, err := readRawList(context.Background(), db, testTweak, cfg.OwnName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[pretty] = len(raw)
+		if strings.Contains(string(raw), "\n  \"servers\"") != pretty {
+			t.Fatalf("pretty %t: unexpected stored list %s", pretty, raw)
+		}
+		if pretty && !json.Valid(raw) {
+			t.Fatalf("expected valid JSON, got %s", raw)
+		}
+		read, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(list) || !sameServer(read[0], list[0]) || !sameServer(read[1], list[1]) {
+			t.Fatalf("pretty %t: expected %v, got %v", pretty, list, read)
+		}
+	}
+	if sizes[true] <= sizes[false] {
+		t.Fatalf("expected the pretty list to be larger, got %d and %d bytes", sizes[true], sizes[false])
+	}
+}
+
+// TestAPIPasswordFile verifies that the API password file takes precedence
+// over SIA_API_PASSWORD, that we fall back to the env var without one and that
+// we require one of them.
+func TestAPIPasswordFile(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SIA_API_PASSWORD", "env")
+	t.Setenv("SERVERLIST_API_PASSWORD_FILE", writeConfigFile(t, "apipassword", "file\n"))
+	cfg, err := getConfig()
+	if err != nil || cfg.SkydApiPassword != "file" {
+		t.Fatalf("expected the password from the file, got %q and %v", cfg.SkydApiPassword, err)
+	}
+
+	t.Setenv("SERVERLIST_API_PASSWORD_FILE", "")
+	cfg, err = getConfig()
+	if err != nil || cfg.SkydApiPassword != "env" {
+		t.Fatalf("expected the password from the env, got %q and %v", cfg.SkydApiPassword, err)
+	}
+
+	for _, path := range []string{writeConfigFile(t, "empty", " \n"), writeConfigFile(t, "missing", "") + ".missing"} {
+		t.Setenv("SERVERLIST_API_PASSWORD_FILE", path)
+		if _, err = getConfig(); err == nil {
+			t.Fatalf("%s: expected an error", path)
+		}
+	}
+	t.Setenv("SERVERLIST_API_PASSWORD_FILE", writeConfigFile(t, "empty", ""))
+	if _, err = getConfig(); !errors.Contains(err, ErrMissingAPIPassword) {
+		t.Fatalf("expected ErrMissingAPIPassword for an empty file, got %v", err)
+	}
+
+	t.Setenv("SERVERLIST_API_PASSWORD_FILE", "")
+	t.Setenv("SIA_API_PASSWORD", "")
+	if _, err = getConfig(); !errors.Contains(err, ErrMissingAPIPassword) {
+		t.Fatalf("expected ErrMissingAPIPassword, got %v", err)
+	}
+}
+
+// TestSuccessCheckFields verifies that the success check fails when our record
+// is fresh but another server wrote it back with outdated addresses.
+func TestSuccessCheckFields(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	wrote := server{Name: cfg.OwnName, IP: "1.1.1.1", IPs: []string{"1.1.1.1", "::1"}, Port: 9980, LastAnnounce: testTime}
+	ip, port, ips := wrote, wrote, wrote
+	ip.IP = "9.9.9.9"
+	port.Port = 443
+	ips.IPs = []string{"1.1.1.1"}
+	// A record which only differs in its health is still ours.
+	healthy := wrote
+	healthy.Healthy = true
+
+	tests := []struct {
+		name    string
+		stored  server
+		problem string
+	}{
+		{"same", wrote, ""},
+		{"health", healthy, ""},
+		{"ip", ip, "has ip 9.9.9.9 instead of 1.1.1.1"},
+		{"port", port, "has port 443 instead of 9980"},
+		{"ips", ips, "has ips"},
+	}
+	for _, tt := range tests {
+		db := newFakeDB()
+		db.storeList(t, testTweak, []server{tt.stored})
+		err := checkSuccess(context.Background(), db, cfg, testTweak, []server{wrote})
+		if tt.problem == "" && err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)) {
+			t.Fatalf("%s: expected an error containing %q, got %v", tt.name, tt.problem, err)
+		}
+	}
+}
+
+// TestOwnNamePort verifies that a port in SKYNET_SERVER_API never ends up in
+// our name, that a non-default one is announced in the port field and that a
+// server which used to announce without one keeps a single record.
+func TestOwnNamePort(t *testing.T) {
+	setTestEnv(t)
+	tests := []struct {
+		value string
+		port  int
+	}{
+		{"dev1.siasky.dev", 0},
+		{"https://dev1.siasky.dev:443", 0},
+		{"http://dev1.siasky.dev:80", 0},
+		{"dev1.siasky.dev:443", 0},
+		{"https://dev1.siasky.dev:9980", 9980},
+		{"dev1.siasky.dev:9980", 9980},
+	}
+	for _, tt := range tests {
+		t.Setenv("SKYNET_SERVER_API", tt.value)
+		cfg, err := getConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.OwnName != "dev1.siasky.dev" || cfg.OwnPort != tt.port {
+			t.Fatalf("%s: expected dev1.siasky.dev and port %d, got %s and %d", tt.value, tt.port, cfg.OwnName, cfg.OwnPort)
+		}
+	}
+	t.Setenv("SKYNET_SERVER_PORT", "9981")
+	if _, err := getConfig(); !errors.Contains(err, ErrInvalidPort) {
+		t.Fatalf("expected conflicting ports to be rejected, got %v", err)
+	}
+	t.Setenv("SKYNET_SERVER_PORT", "")
+
+	// testConfig would reset SKYNET_SERVER_API, so we shorten the delays
+	// ourselves.
+	t.Setenv("SKYNET_SERVER_API", "https://dev1.siasky.dev:9980")
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.StabilizeDelay, cfg.CheckPollDelay, cfg.Spread = 0, time.Millisecond, 0
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{{Name: "dev1.siasky.dev", IP: "1.1.1.1", LastAnnounce: time.Now().Add(-time.Hour)}})
+	if _, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 1 || stored[0].Name != "dev1.siasky.dev" || stored[0].Port != 9980 {
+		t.Fatalf("expected a single record with port 9980, got %v", stored)
+	}
+}
+
+// TestAllowedNames verifies that SERVERLIST_ALLOWED_NAMES lets the listed
+// names announce and rejects everyone else, including our aliases, when we
+// load the config.
+func TestAllowedNames(t *testing.T) {
+	setTestEnv(t)
+	tests := []struct {
+		names   string
+		allowed string
+		valid   bool
+	}{
+		{"dev1.siasky.dev", "", true},
+		{"dev1.siasky.dev", "dev1.siasky.dev", true},
+		{"dev1.siasky.dev", "dev2.siasky.dev, https://dev1.siasky.dev/", true},
+		{"dev1.siasky.dev,alias.siasky.dev", "dev1.siasky.dev,alias.siasky.dev", true},
+		{"dev1.siasky.dev", "dev2.siasky.dev", false},
+		{"dev1.siasky.dev,alias.siasky.dev", "dev1.siasky.dev", false},
+		{"dev1.siasky.dev", "dev1.siasky.dev,not a name", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("SKYNET_SERVER_API", tt.names)
+		t.Setenv("SERVERLIST_ALLOWED_NAMES", tt.allowed)
+		_, err := getConfig()
+		if (err == nil) != tt.valid {
+			t.Fatalf("%s allowing %q: expected valid %t, got %v", tt.names, tt.allowed, tt.valid, err)
+		}
+	}
+	t.Setenv("SKYNET_SERVER_API", "dev1.siasky.dev")
+	t.Setenv("SERVERLIST_ALLOWED_NAMES", "dev2.siasky.dev")
+	if _, err := getConfig(); !errors.Contains(err, ErrInvalidOwnName) {
+		t.Fatalf("expected ErrInvalidOwnName, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+// mergeLists applies our own records to the given, freshly read list. It's
+// used when the list changed since we read it, so we keep the changes other
+// servers made in the meantime instead of starting over. The rules are:
+// * each of our records replaces its counterpart on the base list, regardless
+// of their announce times, since we know our own state best;
+// * the records of other servers are preserved as they are on the base list;
+// * when a list has several records of the same server, the one with the most
+// recent announce wins, see dedupServers;
+// * the records of aliases which our record on the base list lists but ours
+// doesn't are removed, see retiredAliases.
+// A record of ours with a node ID also replaces the record of the same name
+// without one, like ownRecordIndex does, so setting a node ID doesn't leave the
+// old record behind. Neither of the given lists is modified.
+func mergeLists(base, ours []server) []server {
+	ours = dedupServers(append([]server(nil), ours...))
+	merged := dedupServers(append([]server(nil), base...))
+	retired := make(map[string]bool)
+	for _, o := range ours {
+		for _, s := range merged {
+			if replacesRecord(o, s) {
+				for name := range retiredAliases(s, o) {
+					retired[name] = true
+				}
+			}
+		}
+	}
+	for _, o := range ours {
+		delete(retired, o.Name)
+	}
+	merged = dropRetiredAliases(merged, retired)
+	for _, o := range ours {
+		replaced := false
+		var updated []server
+		for _, s := range merged {
+			if !replacesRecord(o, s) {
+				updated = append(updated, s)
+				continue
+			}
+			// Our record takes the place of the first record it replaces.
+			if !replaced {
+				updated = append(updated, o)
+				replaced = true
+			}
+		}
+		if !replaced {
+			updated = append(updated, o)
+		}
+		merged = updated
+	}
+	return merged
+}
+
+// ownRecords returns our own record and the records of our aliases on the
+// given list.
+func ownRecords(list []server, cfg config) []server {
+	var own []server
+	for _, s := range list {
+		if isServer(s, cfg.OwnName, cfg.NodeID) || isAlias(s, cfg.AliasNames) {
+			own = append(own, s)
+		}
+	}
+	return own
+}
+
+// replacesRecord checks whether our record o replaces the record s, i.e.
+// whether they're records of the same server.
+func replacesRecord(o, s server) bool {
+	return s.key() == o.key() || (o.ID != "" && s.ID == "" && s.Name == o.Name)
+}
+
+// retiredAliases returns the names which the previous version of a record
+// listed as its aliases but the current version doesn't. A name which became
+// the name of a record without a node ID isn't retired, since the record of
+// the former alias is now the record itself.
+func retiredAliases(prev, cur server) map[string]bool {
+	retired := make(map[string]bool)
+	for _, alias := range prev.Aliases {
+		retired[alias] = true
+	}
+	for _, alias := range cur.Aliases {
+		delete(retired, alias)
+	}
+	if cur.ID == "" {
+		delete(retired, cur.Name)
+	}
+	return retired
+}
+
+// dropRetiredAliases removes the records of the given retired aliases from the
+// list. Records of aliases never carry a node ID, see upsertAliases.
+func dropRetiredAliases(list []server, retired map[string]bool) []server {
+	if len(retired) == 0 {
+		return list
+	}
+	var kept []server
+	for _, s := range list {
+		if s.ID == "" && retired[s.Name] {
+			logger.Info("removing the record of an alias we no longer announce", "name", s.Name)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}
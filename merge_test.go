@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetiredAliases verifies that the records of the aliases we no longer
+// announce are removed from the list, both when we update our record and when
+// we merge it onto a list another server wrote.
+func TestRetiredAliases(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	cfg.AliasNames = []string{"a.siasky.dev", "b.siasky.dev"}
+	other := server{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime}
+	list, _, err := updateOwnRecord(context.Background(), []server{other}, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 {
+		t.Fatalf("expected our record, two aliases and another server, got %v", list)
+	}
+	base := append([]server(nil), list...)
+
+	// We stop announcing b.siasky.dev.
+	cfg.AliasNames = []string{"a.siasky.dev"}
+	list, _, err = updateOwnRecord(context.Background(), list, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, s := range list {
+		names[s.Name] = true
+	}
+	if len(list) != 3 || !names["dev1.siasky.dev"] || !names["a.siasky.dev"] || !names["other.siasky.dev"] {
+		t.Fatalf("expected the record of b.siasky.dev to be removed, got %v", list)
+	}
+
+	// Another server wrote the list meanwhile, still with the old alias.
+	merged := mergeLists(base, ownRecords(list, cfg))
+	for _, s := range merged {
+		if s.Name == "b.siasky.dev" {
+			t.Fatalf("the merged list kept the retired alias: %v", merged)
+		}
+	}
+	if len(merged) != 3 {
+		t.Fatalf("unexpected merged list %v", merged)
+	}
+}
+
+// TestRenameOwnRecord verifies that with a node ID, a new name renames our
+// record, while without one, it announces a new record.
+func TestRenameOwnRecord(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	old := server{Name: "old.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime.Add(-time.Hour)}
+
+	list, _, err := updateOwnRecord(context.Background(), []server{old}, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected a new record without a node ID, got %v", list)
+	}
+
+	cfg.NodeID = "0b2f6c4e-7a1d-4b8e-9c3f-5d6e7f8a9b0c"
+	old.ID = cfg.NodeID
+	list, _, err = updateOwnRecord(context.Background(), []server{old}, cfg, staticIP("1.1.1.1"), newFakeSkyd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != cfg.OwnName || list[0].ID != cfg.NodeID {
+		t.Fatalf("expected our record to be renamed, got %v", list)
+	}
+}
+
+// TestMergeLists verifies each of the merge rules and that mergeLists leaves
+// its arguments alone.
+func TestMergeLists(t *testing.T) {
+	t0 := testTime
+	t1 := testTime.Add(time.Hour)
+	rec := func(name, ip string, at time.Time) server {
+		return server{Name: name, IP: ip, LastAnnounce: at}
+	}
+	withID := func(s server, id string) server {
+		s.ID = id
+		return s
+	}
+	withAliases := func(s server, aliases ...string) server {
+		s.Aliases = aliases
+		return s
+	}
+	describe := func(list []server) string {
+		var out []string
+		for _, s := range list {
+			out = append(out, s.Name+"@"+s.IP)
+		}
+		return strings.Join(out, ",")
+	}
+	const id = "4e8c2d1a-7b3f-4c5e-9a6d-1f2e3d4c5b6a"
+
+	tests := []struct {
+		name       string
+		base, ours []server
+		want       string
+	}{
+		{
+			"ours replaces a newer record of ours",
+			[]server{rec("a", "1", t0), rec("own", "2", t1)},
+			[]server{rec("own", "3", t0)},
+			"a@1,own@3",
+		},
+		{
+			"others are preserved in order",
+			[]server{rec("c", "1", t0), rec("a", "2", t0), rec("own", "3", t0), rec("b", "4", t1)},
+			[]server{rec("own", "5", t1)},
+			"c@1,a@2,own@5,b@4",
+		},
+		{
+			"ours is appended when missing",
+			[]server{rec("a", "1", t0)},
+			[]server{rec("own", "2", t1)},
+			"a@1,own@2",
+		},
+		{
+			"the newest duplicate on the base list wins",
+			[]server{rec("a", "1", t0), rec("a", "2", t1), rec("b", "3", t1), rec("b", "4", t0)},
+			[]server{rec("own", "5", t1)},
+			"a@2,b@3,own@5",
+		},
+		{
+			"the newest duplicate of ours wins",
+			[]server{rec("a", "1", t0)},
+			[]server{rec("own", "2", t0), rec("own", "3", t1)},
+			"a@1,own@3",
+		},
+		{
+			"a record with a node ID replaces the one without",
+			[]server{rec("own", "1", t1), rec("a", "2", t0)},
+			[]server{withID(rec("own", "3", t0), id)},
+			"own@3,a@2",
+		},
+		{
+			"aliases are merged like our record",
+			[]server{rec("alias", "1", t1), rec("a", "2", t0)},
+			[]server{withAliases(rec("own", "3", t1), "alias"), rec("alias", "3", t1)},
+			"alias@3,a@2,own@3",
+		},
+		{
+			"retired aliases are removed",
+			[]server{withAliases(rec("own", "1", t0), "old"), rec("old", "1", t0), rec("a", "2", t0)},
+			[]server{rec("own", "3", t1)},
+			"own@3,a@2",
+		},
+		{
+			"empty base list",
+			nil,
+			[]server{rec("own", "1", t1)},
+			"own@1",
+		},
+	}
+	for _, tt := range tests {
+		base, ours := describe(tt.base), describe(tt.ours)
+		if got := describe(mergeLists(tt.base, tt.ours)); got != tt.want {
+			t.Fatalf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+		if describe(tt.base) != base || describe(tt.ours) != ours {
+			t.Fatalf("%s: modified the arguments", tt.name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// stageRead is the stage in which we read the list from SkyDB.
+	stageRead = "read"
+	// stageUpdate is the stage in which we add our own record to the list.
+	stageUpdate = "update"
+	// stageWrite is the stage in which we write the list to SkyDB.
+	stageWrite = "write"
+	// stageCheck is the stage in which we verify that our write persisted.
+	stageCheck = "check"
+	// stageIP is the part of the update stage in which we look up our
+	// external IP.
+	stageIP = "ip"
+
+	// metricsShutdownTimeout is the time we give the metrics and status
+	// servers to finish serving in-flight requests on shutdown.
+	metricsShutdownTimeout = 5 * time.Second
+)
+
+var (
+	// stages lists all announce stages in the order in which they happen.
+	stages = []string{stageRead, stageUpdate, stageWrite, stageCheck}
+	// timedStages lists the stages whose durations we measure.
+	timedStages = []string{stageRead, stageIP, stageWrite, stageCheck}
+
+	// durationBuckets are the upper bounds, in seconds, of the buckets of the
+	// stage duration histograms.
+	durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+type (
+	// metrics keeps track of the health of the announce process. It's safe
+	// for concurrent use and it can serve its values in the Prometheus text
+	// exposition format.
+	metrics struct {
+		attempts    uint64
+		failures    map[string]uint64
+		servers     int
+		lastSuccess time.Time
+		durations   map[string]*histogram
+		mu          sync.Mutex
+	}
+
+	// metricsSnapshot is a one-off JSON view of the metrics, for runs which
+	// aren't scraped.
+	metricsSnapshot struct {
+		Attempts        uint64            `json:"attempts"`
+		Failures        map[string]uint64 `json:"failures"`
+		Servers         int               `json:"servers"`
+		DurationSeconds float64           `json:"duration_seconds"`
+	}
+
+	// histogram counts observed durations in durationBuckets. Like in
+	// Prometheus, the bucket counts are cumulative.
+	histogram struct {
+		buckets []uint64
+		count   uint64
+		sum     float64
+	}
+)
+
+// newMetrics returns a new, empty metrics instance.
+func newMetrics() *metrics {
+	m := &metrics{
+		failures:  make(map[string]uint64),
+		durations: make(map[string]*histogram),
+	}
+	for _, stage := range timedStages {
+		m.durations[stage] = &histogram{buckets: make([]uint64, len(durationBuckets))}
+	}
+	return m
+}
+
+// recordAttempt registers the start of a new announce attempt.
+func (m *metrics) recordAttempt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+}
+
+// recordFailure registers a failed announce attempt at the given stage.
+func (m *metrics) recordFailure(stage string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[stage]++
+}
+
+// recordServers registers the current number of servers in the list.
+func (m *metrics) recordServers(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = n
+}
+
+// recordSuccess registers a successful announce.
+func (m *metrics) recordSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess = time.Now()
+}
+
+// recordDuration registers the time an announce stage took.
+func (m *metrics) recordDuration(stage string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.durations[stage]
+	if !ok {
+		return
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP serverlist_announce_attempts_total Total number of announce attempts.")
+	fmt.Fprintln(w, "# TYPE serverlist_announce_attempts_total counter")
+	fmt.Fprintf(w, "serverlist_announce_attempts_total %d\n", m.attempts)
+	fmt.Fprintln(w, "# HELP serverlist_announce_failures_total Total number of failed announce attempts by stage.")
+	fmt.Fprintln(w, "# TYPE serverlist_announce_failures_total counter")
+	for _, stage := range stages {
+		fmt.Fprintf(w, "serverlist_announce_failures_total{stage=%q} %d\n", stage, m.failures[stage])
+	}
+	fmt.Fprintln(w, "# HELP serverlist_servers Number of servers in the list.")
+	fmt.Fprintln(w, "# TYPE serverlist_servers gauge")
+	fmt.Fprintf(w, "serverlist_servers %d\n", m.servers)
+	fmt.Fprintln(w, "# HELP serverlist_announce_stage_duration_seconds Duration of the announce stages.")
+	fmt.Fprintln(w, "# TYPE serverlist_announce_stage_duration_seconds histogram")
+	for _, stage := range timedStages {
+		h := m.durations[stage]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "serverlist_announce_stage_duration_seconds_bucket{stage=%q,le=\"%g\"} %d\n", stage, bound, h.buckets[i])
+		}
+		fmt.Fprintf(w, "serverlist_announce_stage_duration_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", stage, h.count)
+		fmt.Fprintf(w, "serverlist_announce_stage_duration_seconds_sum{stage=%q} %f\n", stage, h.sum)
+		fmt.Fprintf(w, "serverlist_announce_stage_duration_seconds_count{stage=%q} %d\n", stage, h.count)
+	}
+	// We don't report the time since the last success before we've had one.
+	if !m.lastSuccess.IsZero() {
+		fmt.Fprintln(w, "# HELP serverlist_seconds_since_last_success Seconds since the last successful announce.")
+		fmt.Fprintln(w, "# TYPE serverlist_seconds_since_last_success gauge")
+		fmt.Fprintf(w, "serverlist_seconds_since_last_success %f\n", time.Since(m.lastSuccess).Seconds())
+	}
+}
+
+// snapshot returns the current values of the metrics, together with the given
+// total duration of the run.
+func (m *metrics) snapshot(d time.Duration) metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := make(map[string]uint64, len(stages))
+	for _, stage := range stages {
+		failures[stage] = m.failures[stage]
+	}
+	return metricsSnapshot{
+		Attempts:        m.attempts,
+		Failures:        failures,
+		Servers:         m.servers,
+		DurationSeconds: d.Seconds(),
+	}
+}
+
+// writeMetricsSnapshot writes the given snapshot as JSON to the file at the
+// given path, or to stdout if the path is "-".
+func writeMetricsSnapshot(path string, snap metricsSnapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal metrics snapshot")
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	err = os.WriteFile(path, b, 0644)
+	if err != nil {
+		return errors.AddContext(err, "failed to write metrics snapshot")
+	}
+	return nil
+}
+
+// serveMetrics starts an HTTP server which exposes the given metrics on
+// /metrics. The server shuts down when the context is done.
+func serveMetrics(ctx context.Context, addr string, m *metrics) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return serve(ctx, addr, mux)
+}
+
+// serve starts an HTTP server on addr which serves requests using the given
+// handler. The server shuts down when the context is done.
+func serve(ctx context.Context, addr string, h http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.AddContext(err, "failed to listen on "+addr)
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		err := srv.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("http server failed", "addr", addr, "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	return nil
+}
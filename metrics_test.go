@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestStageDurations verifies that a slow read shows up in the read duration
+// histogram.
+func TestStageDurations(t *testing.T) {
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.onRead = func(n int) error {
+		if n == 1 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		return nil
+	}
+	m := newMetrics()
+	if _, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), m, nil); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	read, write := *m.durations[stageRead], *m.durations[stageWrite]
+	m.mu.Unlock()
+	if read.count != 1 || read.sum < 0.05 || read.buckets[0] != 0 {
+		t.Fatalf("expected a single read of at least 50ms, got %+v", read)
+	}
+	if write.count != 1 {
+		t.Fatalf("expected a single write, got %+v", write)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `serverlist_announce_stage_duration_seconds_count{stage="read"} 1`) {
+		t.Fatalf("expected the read duration in the metrics, got %s", rec.Body.String())
+	}
+}
+
+// TestMetricsSnapshot verifies that the snapshot written at the end of a run
+// reflects a run which needed a retry after a failed read.
+func TestMetricsSnapshot(t *testing.T) {
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{{Name: "other.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()}})
+	db.onRead = func(n int) error {
+		if n == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	opts := options{output: outputText, noSpread: true, metricsSnapshot: path}
+	if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap metricsSnapshot
+	if err = json.Unmarshal(b, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Attempts != 2 || snap.Servers != 2 || snap.DurationSeconds <= 0 {
+		t.Fatalf("unexpected snapshot %s", b)
+	}
+	for _, stage := range stages {
+		want := uint64(0)
+		if stage == stageRead {
+			want = 1
+		}
+		if failures, exists := snap.Failures[stage]; !exists || failures != want {
+			t.Fatalf("expected %d %s failures, got %s", want, stage, b)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"text/template"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// nameTemplateData holds the values available to the name template.
+	// * Hostname is the hostname of the machine.
+	// * Domain is our name from SKYNET_SERVER_API, SERVER_DOMAIN or
+	// PORTAL_DOMAIN, if set.
+	// * Region is the value of SERVERLIST_REGION.
+	// * Environment is the value of SERVERLIST_ENVIRONMENT.
+	nameTemplateData struct {
+		Hostname    string
+		Domain      string
+		Region      string
+		Environment string
+	}
+)
+
+// newNameTemplateData collects the values available to the name template.
+func newNameTemplateData(domain, region string) (nameTemplateData, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nameTemplateData{}, errors.AddContext(err, "failed to get hostname")
+	}
+	return nameTemplateData{
+		Hostname:    hostname,
+		Domain:      domain,
+		Region:      region,
+		Environment: os.Getenv("SERVERLIST_ENVIRONMENT"),
+	}, nil
+}
+
+// renderOwnName renders the given text/template with the given data and
+// validates the result like any other server name, see parseOwnName.
+// Referencing an unknown field is an error.
+func renderOwnName(tmpl string, data nameTemplateData) (string, int, error) {
+	t, err := template.New("name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", 0, errors.AddContext(err, "failed to parse name template")
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", 0, errors.AddContext(err, "failed to render name template")
+	}
+	return parseOwnName(b.String())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestNameTemplate verifies that SERVERLIST_NAME_TEMPLATE replaces the name
+// from SKYNET_SERVER_API, that templates which don't compile or reference an
+// unknown field are rejected, as are names which are also aliases, and that we
+// keep the plain name without one.
+func TestNameTemplate(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_REGION", "eu-west")
+	t.Setenv("SERVERLIST_ENVIRONMENT", "prod")
+
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OwnName != "dev1.siasky.dev" {
+		t.Fatalf("expected dev1.siasky.dev without a template, got %s", cfg.OwnName)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SERVERLIST_NAME_TEMPLATE", "node.{{.Region}}.{{.Environment}}.{{.Domain}}")
+	cfg, err = getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OwnName != "node.eu-west.prod.dev1.siasky.dev" {
+		t.Fatalf("unexpected name %s", cfg.OwnName)
+	}
+	name, _, err := renderOwnName("{{.Hostname}}.siasky.dev", nameTemplateData{Hostname: hostname})
+	if err != nil || name != hostname+".siasky.dev" {
+		t.Fatalf("expected %s.siasky.dev, got %s and %v", hostname, name, err)
+	}
+
+	// The template alone is enough.
+	t.Setenv("SKYNET_SERVER_API", "")
+	t.Setenv("SERVERLIST_NAME_TEMPLATE", "node.{{.Region}}.siasky.dev")
+	cfg, err = getConfig()
+	if err != nil || cfg.OwnName != "node.eu-west.siasky.dev" {
+		t.Fatalf("expected node.eu-west.siasky.dev, got %s and %v", cfg.OwnName, err)
+	}
+
+	// The rendered name must not be one of our aliases.
+	t.Setenv("SKYNET_SERVER_API", "dev1.siasky.dev,eu-west.siasky.dev")
+	t.Setenv("SERVERLIST_NAME_TEMPLATE", "{{.Region}}.siasky.dev")
+	if _, err = getConfig(); !errors.Contains(err, ErrInvalidOwnName) {
+		t.Fatalf("expected a name which is also an alias to be rejected, got %v", err)
+	}
+	t.Setenv("SKYNET_SERVER_API", "")
+
+	for _, tmpl := range []string{"{{.Datacenter}}.siasky.dev", "{{.Region", "{{.Region}}/path"} {
+		t.Setenv("SERVERLIST_NAME_TEMPLATE", tmpl)
+		if _, err = getConfig(); !errors.Contains(err, ErrInvalidOwnName) {
+			t.Fatalf("%q: expected ErrInvalidOwnName, got %v", tmpl, err)
+		}
+	}
+}
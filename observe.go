@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// observe reads each list on the configured interval and records what it
+// finds in the given metrics and status, until the context is done. It never
+// writes to the lists, so it's safe to run on hosts which aren't servers.
+func observe(ctx context.Context, db skyDB, cfg config, pk crypto.PublicKey, m *metrics, st *status) {
+	for {
+		start := time.Now()
+		for _, tweak := range cfg.Tweaks {
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			readCtx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			list, rev, err := getServerList(readCtx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Error("failed to read server list", "skylink", sl.String(), "error", err)
+				st.recordError(err)
+				continue
+			}
+			logger.Info("read server list", "skylink", sl.String(), "revision", rev, "servers", len(list))
+			m.recordServers(len(list))
+			st.recordObservation(sl.String(), list)
+		}
+		if !sleep(ctx, time.Until(start.Add(jitteredInterval(cfg.Interval, cfg.IntervalJitter)))) {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestObserve verifies that observe mode keeps reading the list and reports
+// it through the status and metrics, but never writes to it.
+func TestObserve(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Interval = time.Millisecond
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	list := []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: time.Now()},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now()},
+	}
+	db := newFakeDB()
+	db.storeList(t, testTweak, list)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.onRead = func(n int) error {
+		if n == 3 {
+			cancel()
+		}
+		return nil
+	}
+	m, st := newMetrics(), newStatus(cfg.StaleAfter)
+	observe(ctx, db, cfg, pk, m, st)
+	if db.readCount() < 3 {
+		t.Fatalf("expected observe to keep reading, got %d reads", db.readCount())
+	}
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes, got %d", db.writeCount())
+	}
+	resp := st.response()
+	if resp.Skylink == "" || resp.Servers != len(list) || len(resp.List) != len(list) {
+		t.Fatalf("unexpected status %+v", resp)
+	}
+	if m.servers != len(list) {
+		t.Fatalf("expected %d servers in the metrics, got %d", len(list), m.servers)
+	}
+
+	// The same holds for a run in observe mode.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	db.onRead = func(n int) error {
+		if n == 6 {
+			cancel()
+		}
+		return nil
+	}
+	if code := run(ctx, cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), options{observe: true}); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes, got %d", db.writeCount())
+	}
+}
+
+// TestObserveConfig verifies that observe mode gets by without our name and
+// the API password, which announcing requires.
+func TestObserveConfig(t *testing.T) {
+	setTestEnv(t)
+	t.Setenv("SKYNET_SERVER_API", "")
+	t.Setenv("SIA_API_PASSWORD", "")
+	if _, err := getConfig(); !errors.Contains(err, ErrMissingOwnName) {
+		t.Fatalf("expected ErrMissingOwnName, got %v", err)
+	}
+	cfg, err := getReadOnlyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OwnName != "" || cfg.SkydApiPassword != "" || len(cfg.Tweaks) == 0 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	// A name which is set still has to be valid.
+	t.Setenv("SKYNET_SERVER_API", "dev1.siasky.dev/path")
+	if _, err = getReadOnlyConfig(); !errors.Contains(err, ErrInvalidOwnName) {
+		t.Fatalf("expected ErrInvalidOwnName, got %v", err)
+	}
+}
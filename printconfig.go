@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// redactedConfig returns a view of the config which is safe to print. Secrets
+// only show their length, so a truncated or empty one still stands out.
+func redactedConfig(cfg config) map[string]interface{} {
+	redacted := make(map[string]interface{})
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		redacted[t.Field(i).Name] = v.Field(i).Interface()
+	}
+	redacted["Entropy"] = fmt.Sprintf("redacted (%d bytes)", len(cfg.Entropy))
+	redacted["SkydApiPassword"] = fmt.Sprintf("redacted (%d characters)", len(cfg.SkydApiPassword))
+	redacted["NodeKey"] = fmt.Sprintf("redacted (%d bytes)", len(cfg.NodeKey))
+	// The public key isn't secret and other servers need it in order to pin
+	// ours, see SERVERLIST_TRUSTED_KEYS.
+	if len(cfg.NodeKey) > 0 {
+		var seed [crypto.EntropySize]byte
+		copy(seed[:], cfg.NodeKey)
+		_, pk := crypto.GenerateKeyPairDeterministic(seed)
+		redacted["NodePublicKey"] = hex.EncodeToString(pk[:])
+	}
+	trusted := make(map[string]string, len(cfg.TrustedKeys))
+	for name, pk := range cfg.TrustedKeys {
+		trusted[name] = hex.EncodeToString(pk[:])
+	}
+	redacted["TrustedKeys"] = trusted
+	redacted["StatusToken"] = fmt.Sprintf("redacted (%d characters)", len(cfg.StatusToken))
+	redacted["WebhookURL"] = redactedURL(cfg.WebhookURL)
+	tweaks := make([]string, 0, len(cfg.Tweaks))
+	for _, tweak := range cfg.Tweaks {
+		tweaks = append(tweaks, hex.EncodeToString(tweak[:]))
+	}
+	redacted["Tweaks"] = tweaks
+	backupTweaks := make([]string, 0, len(cfg.BackupTweaks))
+	for _, tweak := range cfg.BackupTweaks {
+		backupTweaks = append(backupTweaks, hex.EncodeToString(tweak[:]))
+	}
+	redacted["BackupTweaks"] = backupTweaks
+	// A cert pool doesn't tell us anything useful when printed, so we only
+	// show whether we use a custom one.
+	redacted["SkydRootCAs"] = cfg.SkydRootCAs != nil
+	for name, value := range redacted {
+		switch value := value.(type) {
+		case time.Duration:
+			redacted[name] = value.String()
+		case slog.Level:
+			redacted[name] = value.String()
+		}
+	}
+	return redacted
+}
+
+// redactedURL returns the scheme and host of the given URL. Webhook URLs often
+// carry a token in their path or query, so we drop everything else.
+func redactedURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "redacted"
+	}
+	return fmt.Sprintf("%s://%s/redacted", u.Scheme, u.Host)
+}
+
+// printConfig prints the redacted config as JSON.
+func printConfig(w io.Writer, cfg config) error {
+	b, err := json.MarshalIndent(redactedConfig(cfg), "", "  ")
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal config")
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestPrintConfig verifies that the printed config is JSON which shows the
+// tweak in full but never the API password, the entropy, the status token or
+// the path of the webhook URL.
+func TestPrintConfig(t *testing.T) {
+	setTestEnv(t)
+	password := "correct-horse-battery-staple"
+	entropy := hex.EncodeToString(bytes.Repeat([]byte{0xab}, 32))
+	token := "status-token-value"
+	hook := "T0123/B4567/webhook-secret"
+	t.Setenv("SIA_API_PASSWORD", password)
+	t.Setenv("SERVERLIST_WEBHOOK_URL", "https://hooks.example.com/services/"+hook+"?key=query-secret")
+	t.Setenv("SERVERLIST_ENTROPY", entropy)
+	t.Setenv("SERVERLIST_STATUS_TOKEN", token)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err = printConfig(&b, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	var printed map[string]interface{}
+	if err = json.Unmarshal(b.Bytes(), &printed); err != nil {
+		t.Fatalf("expected JSON, got %v", err)
+	}
+	for _, secret := range []string{password, entropy, token, hook, "query-secret"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("expected %q to be redacted, got %s", secret, out)
+		}
+	}
+	if printed["SkydApiPassword"] != "redacted (28 characters)" || printed["Entropy"] != "redacted (32 bytes)" {
+		t.Fatalf("unexpected redaction %v and %v", printed["SkydApiPassword"], printed["Entropy"])
+	}
+	if printed["WebhookURL"] != "https://hooks.example.com/redacted" {
+		t.Fatalf("unexpected webhook URL %v", printed["WebhookURL"])
+	}
+	if !strings.Contains(out, hex.EncodeToString(testTweak[:])) {
+		t.Fatalf("expected the full tweak, got %s", out)
+	}
+}
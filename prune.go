@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// prunePolicyTime selects the TimeCutoffPolicy.
+	prunePolicyTime = "time"
+	// prunePolicyCount selects the MaxCountPolicy.
+	prunePolicyCount = "count"
+)
+
+type (
+	// PrunePolicy decides which servers remain on a list.
+	PrunePolicy interface {
+		// Prune returns the servers which remain on the list at the given
+		// time. It must not modify the given list.
+		Prune(list []server, now time.Time) []server
+	}
+
+	// TimeCutoffPolicy removes the servers which haven't announced within
+	// PruneAfter. It never removes the record of the server with the given
+	// name and node ID, i.e. our own, regardless of its age.
+	TimeCutoffPolicy struct {
+		PruneAfter time.Duration
+		OwnName    string
+		NodeID     string
+	}
+
+	// MaxCountPolicy keeps at most MaxServers servers on the list, dropping
+	// the ones with the oldest announces. It never removes the record of the
+	// server with the given name and node ID, i.e. our own, nor the records
+	// of its AliasNames.
+	MaxCountPolicy struct {
+		MaxServers int
+		OwnName    string
+		NodeID     string
+		AliasNames []string
+	}
+)
+
+// Prune implements PrunePolicy.
+func (p TimeCutoffPolicy) Prune(list []server, now time.Time) []server {
+	cutoff := now.Add(-p.PruneAfter)
+	var updatedList []server
+	for _, s := range list {
+		if s.LastAnnounce.After(cutoff) || isServer(s, p.OwnName, p.NodeID) {
+			updatedList = append(updatedList, s)
+		}
+	}
+	return updatedList
+}
+
+// Prune implements PrunePolicy. It preserves the order of the remaining
+// servers.
+func (p MaxCountPolicy) Prune(list []server, _ time.Time) []server {
+	if len(list) <= p.MaxServers {
+		return list
+	}
+	keep := make([]bool, len(list))
+	n := p.MaxServers
+	// Order the other servers from the newest announce to the oldest, keeping
+	// servers with the same announce time in list order.
+	byAge := make([]int, 0, len(list))
+	for i, s := range list {
+		if isServer(s, p.OwnName, p.NodeID) || isAlias(s, p.AliasNames) {
+			keep[i] = true
+			n--
+			continue
+		}
+		byAge = append(byAge, i)
+	}
+	if n < 0 {
+		n = 0
+	}
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return list[byAge[i]].LastAnnounce.After(list[byAge[j]].LastAnnounce)
+	})
+	for _, i := range byAge[:n] {
+		keep[i] = true
+	}
+	var pruned []server
+	for i, s := range list {
+		if keep[i] {
+			pruned = append(pruned, s)
+		}
+	}
+	return pruned
+}
+
+// prunePolicy returns the pruning policy selected by the config.
+func prunePolicy(cfg config) PrunePolicy {
+	if cfg.PrunePolicy == prunePolicyCount {
+		return MaxCountPolicy{MaxServers: cfg.MaxServers, OwnName: cfg.OwnName, NodeID: cfg.NodeID, AliasNames: cfg.AliasNames}
+	}
+	return TimeCutoffPolicy{PruneAfter: cfg.PruneAfter, OwnName: cfg.OwnName, NodeID: cfg.NodeID}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestCheckShrink verifies that checkShrink refuses empty lists and lists which
+// drop too many servers, not counting the ones which pruning drops anyway and
+// the records of retired aliases.
+func TestCheckShrink(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	cfg.MaxShrink = 0.5
+	fresh := func(name string) server {
+		return server{Name: name, LastAnnounce: testTime}
+	}
+	expired := func(name string) server {
+		return server{Name: name, LastAnnounce: testTime.Add(-2 * cfg.PruneAfter)}
+	}
+	a, b, c, d := fresh("a.siasky.dev"), fresh("b.siasky.dev"), fresh("c.siasky.dev"), fresh("d.siasky.dev")
+
+	if err := checkShrink([]server{a}, nil, cfg); !errors.Contains(err, ErrListShrunk) {
+		t.Fatalf("expected an empty list to be refused, got %v", err)
+	}
+	if err := checkShrink([]server{a, b, c, d}, []server{a, b}, cfg); err != nil {
+		t.Fatalf("expected dropping half of the servers to pass, got %v", err)
+	}
+	if err := checkShrink([]server{a, b, c, d}, []server{a}, cfg); !errors.Contains(err, ErrListShrunk) {
+		t.Fatalf("expected dropping 3 of 4 servers to be refused, got %v", err)
+	}
+	// Pruning the expired servers is expected, so only the drop of b counts.
+	read := []server{a, b, expired("x.siasky.dev"), expired("y.siasky.dev"), expired("z.siasky.dev")}
+	if err := checkShrink(read, []server{a}, cfg); err != nil {
+		t.Fatalf("expected pruned servers not to count, got %v", err)
+	}
+	// An unexpected drop still counts against the pruned list.
+	if err := checkShrink(append(read, c), []server{a}, cfg); !errors.Contains(err, ErrListShrunk) {
+		t.Fatalf("expected dropping 2 of 3 unpruned servers to be refused, got %v", err)
+	}
+
+	// Dropping the records of the aliases we retired is expected as well.
+	own := server{Name: cfg.OwnName, LastAnnounce: testTime, Aliases: []string{"x.siasky.dev", "y.siasky.dev", "z.siasky.dev"}}
+	read = []server{own, fresh("x.siasky.dev"), fresh("y.siasky.dev"), fresh("z.siasky.dev"), a}
+	cfg.AliasNames = nil
+	if err := checkShrink(read, []server{{Name: cfg.OwnName, LastAnnounce: testTime}, a}, cfg); err != nil {
+		t.Fatalf("expected retired aliases not to count, got %v", err)
+	}
+	if err := checkShrink(read, []server{{Name: cfg.OwnName, LastAnnounce: testTime}}, cfg); !errors.Contains(err, ErrListShrunk) {
+		t.Fatalf("expected dropping the only other server to be refused, got %v", err)
+	}
+}
+
+// TestPruneServers verifies that pruneServers drops expired servers only when
+// pruning is enabled.
+func TestPruneServers(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	list := []server{
+		{Name: "a.siasky.dev", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", LastAnnounce: testTime.Add(-2 * cfg.PruneAfter)},
+		{Name: "c.siasky.dev", LastAnnounce: testTime.Add(time.Minute - cfg.PruneAfter)},
+	}
+	pruned := pruneServers(list, cfg)
+	if len(pruned) != 2 || pruned[0].Name != "a.siasky.dev" || pruned[1].Name != "c.siasky.dev" {
+		t.Fatalf("expected b.siasky.dev to be pruned, got %v", pruned)
+	}
+	cfg.Prune = false
+	if len(pruneServers(list, cfg)) != 3 {
+		t.Fatal("expected no pruning when it's disabled")
+	}
+}
+
+// TestPruneAfterConfig verifies that SERVERLIST_PRUNE_AFTER defaults to a week,
+// that it's honored when pruning and that it must be a positive duration.
+func TestPruneAfterConfig(t *testing.T) {
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PruneAfter != 168*time.Hour {
+		t.Fatalf("expected a default of 168h, got %v", cfg.PruneAfter)
+	}
+
+	t.Setenv("SERVERLIST_PRUNE_AFTER", "48h")
+	cfg, err = getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setClock(t, testTime)
+	list := []server{
+		{Name: "new.siasky.dev", LastAnnounce: testTime.Add(-47 * time.Hour)},
+		{Name: "old.siasky.dev", LastAnnounce: testTime.Add(-49 * time.Hour)},
+	}
+	pruned := pruneServers(list, cfg)
+	if len(pruned) != 1 || pruned[0].Name != "new.siasky.dev" {
+		t.Fatalf("expected only the server older than 48h to be pruned, got %v", pruned)
+	}
+
+	for _, value := range []string{"0s", "-1h", "a week"} {
+		t.Setenv("SERVERLIST_PRUNE_AFTER", value)
+		if _, err = getConfig(); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestPruneBoundary verifies that a server gets pruned once the clock passes
+// its prune time and not a moment earlier.
+func TestPruneBoundary(t *testing.T) {
+	cfg := testConfig(t)
+	list := []server{{Name: "a.siasky.dev", LastAnnounce: testTime}}
+	setClock(t, testTime.Add(cfg.PruneAfter-time.Second))
+	if len(pruneServers(list, cfg)) != 1 {
+		t.Fatal("expected the server to be kept before its prune time")
+	}
+	setClock(t, testTime.Add(cfg.PruneAfter+time.Second))
+	if len(pruneServers(list, cfg)) != 0 {
+		t.Fatal("expected the server to be pruned after its prune time")
+	}
+}
+
+// TestPruneKeepsOwnRecord verifies that pruning never drops our own record,
+// however old it is, while it still drops the stale records of others.
+func TestPruneKeepsOwnRecord(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	old := testTime.Add(-2 * cfg.PruneAfter)
+	list := []server{
+		{Name: "a.siasky.dev", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", LastAnnounce: old},
+		{Name: cfg.OwnName, LastAnnounce: old},
+	}
+	pruned := pruneServers(list, cfg)
+	if len(pruned) != 2 || pruned[0].Name != "a.siasky.dev" || pruned[1].Name != cfg.OwnName {
+		t.Fatalf("expected b.siasky.dev to be pruned, got %v", pruned)
+	}
+	pruned = TimeCutoffPolicy{PruneAfter: cfg.PruneAfter, OwnName: cfg.OwnName}.Prune(list[1:], testTime)
+	if len(pruned) != 1 || pruned[0].Name != cfg.OwnName {
+		t.Fatalf("expected only our record to remain, got %v", pruned)
+	}
+}
+
+// TestPrunePolicies verifies each of the shipped policies in isolation and
+// that SERVERLIST_PRUNE_POLICY selects them.
+func TestPrunePolicies(t *testing.T) {
+	list := []server{
+		{Name: "a.siasky.dev", LastAnnounce: testTime.Add(-time.Hour)},
+		{Name: "own.siasky.dev", LastAnnounce: testTime.Add(-72 * time.Hour)},
+		{Name: "b.siasky.dev", LastAnnounce: testTime.Add(-48 * time.Hour)},
+		{Name: "c.siasky.dev", LastAnnounce: testTime},
+	}
+	names := func(list []server) string {
+		var n []string
+		for _, s := range list {
+			n = append(n, s.Name)
+		}
+		return strings.Join(n, ",")
+	}
+
+	tests := []struct {
+		policy PrunePolicy
+		want   string
+	}{
+		{TimeCutoffPolicy{PruneAfter: 24 * time.Hour}, "a.siasky.dev,c.siasky.dev"},
+		{TimeCutoffPolicy{PruneAfter: 24 * time.Hour, OwnName: "own.siasky.dev"}, "a.siasky.dev,own.siasky.dev,c.siasky.dev"},
+		{TimeCutoffPolicy{PruneAfter: time.Minute}, "c.siasky.dev"},
+		{MaxCountPolicy{MaxServers: 10}, "a.siasky.dev,own.siasky.dev,b.siasky.dev,c.siasky.dev"},
+		{MaxCountPolicy{MaxServers: 2}, "a.siasky.dev,c.siasky.dev"},
+		{MaxCountPolicy{MaxServers: 2, OwnName: "own.siasky.dev"}, "own.siasky.dev,c.siasky.dev"},
+		{MaxCountPolicy{MaxServers: 3, OwnName: "own.siasky.dev", AliasNames: []string{"b.siasky.dev"}}, "own.siasky.dev,b.siasky.dev,c.siasky.dev"},
+		{MaxCountPolicy{MaxServers: 1, OwnName: "own.siasky.dev", AliasNames: []string{"b.siasky.dev"}}, "own.siasky.dev,b.siasky.dev"},
+	}
+	for _, tt := range tests {
+		before := names(list)
+		if got := names(tt.policy.Prune(list, testTime)); got != tt.want {
+			t.Fatalf("%+v: expected %s, got %s", tt.policy, tt.want, got)
+		}
+		if names(list) != before {
+			t.Fatalf("%+v: modified the list", tt.policy)
+		}
+	}
+
+	cfg := testConfig(t)
+	if _, ok := prunePolicy(cfg).(TimeCutoffPolicy); !ok {
+		t.Fatalf("expected the time policy by default, got %T", prunePolicy(cfg))
+	}
+	t.Setenv("SERVERLIST_PRUNE_POLICY", prunePolicyCount)
+	cfg = testConfig(t)
+	if _, ok := prunePolicy(cfg).(MaxCountPolicy); !ok {
+		t.Fatalf("expected the count policy, got %T", prunePolicy(cfg))
+	}
+	t.Setenv("SERVERLIST_PRUNE_POLICY", "health")
+	if _, err := getConfig(); err == nil {
+		t.Fatal("expected an unknown policy to be rejected")
+	}
+}
+
+// TestPruneDisabled verifies that SERVERLIST_PRUNE=false keeps stale servers on
+// the list through an announce.
+func TestPruneDisabled(t *testing.T) {
+	setTestEnv(t)
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Prune {
+		t.Fatal("expected pruning to be enabled by default")
+	}
+	t.Setenv("SERVERLIST_PRUNE", "maybe")
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected an invalid value to be rejected")
+	}
+	t.Setenv("SERVERLIST_PRUNE", "false")
+	cfg = testConfig(t)
+	if cfg.Prune {
+		t.Fatal("expected pruning to be disabled")
+	}
+
+	stale := server{Name: "ancient.siasky.dev", IP: "2.2.2.2", LastAnnounce: time.Now().Add(-100 * cfg.PruneAfter)}
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{stale})
+	if _, err = announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	if len(stored) != 2 || ownRecordIndex(stored, stale.Name, "") < 0 {
+		t.Fatalf("expected %s to survive, got %v", stale.Name, stored)
+	}
+}
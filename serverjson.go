@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// lastAnnounceUnixField is the JSON name of the announce time as a Unix
+	// timestamp. We write it alongside the RFC3339 last_announce field for the
+	// benefit of non-Go consumers of the list. LastAnnounce remains the
+	// canonical field, we only fall back to this one when it's missing.
+	lastAnnounceUnixField = "last_announce_unix"
+)
+
+var (
+	// serverJSONFields holds the JSON names of all server fields we know
+	// about.
+	serverJSONFields = append(jsonFieldNames(reflect.TypeOf(server{})), lastAnnounceUnixField)
+)
+
+// jsonFieldNames returns the JSON names of all fields of the given struct
+// type which get marshalled.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// isKnownServerField checks whether the given JSON key maps to one of our
+// server fields. Like encoding/json, it matches names case-insensitively.
+func isKnownServerField(key string) bool {
+	for _, name := range serverJSONFields {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON decodes a server, keeping all fields we don't know about in
+// Extra. This allows us to write back fields added by newer versions of the
+// tool without losing them.
+func (s *server) UnmarshalJSON(b []byte) error {
+	// plain has the same fields as server but none of its methods, which
+	// prevents infinite recursion.
+	type plain server
+	var p plain
+	err := json.Unmarshal(b, &p)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+	p.Extra = nil
+	for key, value := range fields {
+		if strings.EqualFold(key, lastAnnounceUnixField) && p.LastAnnounce.IsZero() {
+			var unix int64
+			err = json.Unmarshal(value, &unix)
+			if err != nil {
+				return err
+			}
+			p.LastAnnounce = time.Unix(unix, 0).UTC()
+		}
+		if isKnownServerField(key) {
+			continue
+		}
+		if p.Extra == nil {
+			p.Extra = make(map[string]json.RawMessage)
+		}
+		p.Extra[key] = value
+	}
+	*s = server(p)
+	return nil
+}
+
+// MarshalJSON encodes a server, including the announce time as a Unix
+// timestamp and the unknown fields we kept in Extra. Known fields take
+// precedence over unknown ones with the same name.
+func (s server) MarshalJSON() ([]byte, error) {
+	type plain server
+	b, err := json.Marshal(plain(s))
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+	if !s.LastAnnounce.IsZero() {
+		fields[lastAnnounceUnixField] = json.RawMessage(strconv.FormatInt(s.LastAnnounce.Unix(), 10))
+	}
+	for key, value := range s.Extra {
+		if _, exists := fields[key]; !exists {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
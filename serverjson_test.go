@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestServerPort verifies that records round-trip with and without a port and
+// that records without one omit the field, like older versions wrote them.
+func TestServerPort(t *testing.T) {
+	for _, port := range []int{0, 9980} {
+		s := server{Name: "a.siasky.dev", IP: "1.1.1.1", Port: port, LastAnnounce: testTime}
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), `"port"`) != (port != 0) {
+			t.Fatalf("unexpected encoding %s", b)
+		}
+		var decoded server
+		err = json.Unmarshal(b, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Port != port || !sameServer(s, decoded) {
+			t.Fatalf("expected %v, got %v", s, decoded)
+		}
+	}
+
+	setTestEnv(t)
+	t.Setenv("SKYNET_SERVER_PORT", "9980")
+	cfg, err := getConfig()
+	if err != nil || cfg.OwnPort != 9980 {
+		t.Fatalf("expected port 9980, got %d and %v", cfg.OwnPort, err)
+	}
+	for _, value := range []string{"0", "65536", "http"} {
+		t.Setenv("SKYNET_SERVER_PORT", value)
+		if _, err = getConfig(); !errors.Contains(err, ErrInvalidPort) {
+			t.Fatalf("%s: expected ErrInvalidPort, got %v", value, err)
+		}
+	}
+}
+
+// TestUnknownFields verifies that fields we don't know about survive a
+// read-modify-write cycle on the records of other servers, while our own
+// record is fully overwritten. The region field was the original example of
+// such a field, before we learned about it.
+func TestUnknownFields(t *testing.T) {
+	cfg := testConfig(t)
+	now := time.Now().UTC().Format(time.RFC3339)
+	db := newFakeDB()
+	db.storeRaw(testTweak, []byte(`{"version":1,"servers":[`+
+		`{"name":"other.siasky.dev","ip":"2.2.2.2","last_announce":"`+now+`","datacenter":"fra1","region":"eu-west"},`+
+		`{"name":"`+cfg.OwnName+`","ip":"1.1.1.1","last_announce":"`+now+`","datacenter":"ams3"}]}`))
+	if _, err := announce(context.Background(), db, newFakeSkyd(), cfg, testTweak, staticIP("1.1.1.1"), newMetrics(), nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := db.storedList(t, testTweak)
+	i, own := ownRecordIndex(stored, "other.siasky.dev", ""), ownRecordIndex(stored, cfg.OwnName, "")
+	if i < 0 || own < 0 {
+		t.Fatalf("unexpected list %v", stored)
+	}
+	if string(stored[i].Extra["datacenter"]) != `"fra1"` || stored[i].Region != "eu-west" {
+		t.Fatalf("expected the fields of other.siasky.dev to survive, got %v", stored[i])
+	}
+	if len(stored[own].Extra) != 0 {
+		t.Fatalf("expected our record to be overwritten, got %v", stored[own].Extra)
+	}
+}
+
+// TestLastAnnounceUnix verifies that records carry the announce time in both
+// representations and that records which only carry the Unix timestamp decode
+// to the same time, so pruning and the success check keep working on them.
+func TestLastAnnounceUnix(t *testing.T) {
+	s := server{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["last_announce"]) != `"`+testTime.Format(time.RFC3339)+`"` {
+		t.Fatalf("unexpected last_announce %s", fields["last_announce"])
+	}
+	if string(fields["last_announce_unix"]) != "1654084800" {
+		t.Fatalf("unexpected last_announce_unix %s", fields["last_announce_unix"])
+	}
+	var decoded server
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.LastAnnounce.Equal(testTime) || decoded.Extra != nil {
+		t.Fatalf("expected %v, got %v", s, decoded)
+	}
+
+	// A consumer which only writes the Unix timestamp.
+	err = json.Unmarshal([]byte(`{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce_unix":1654084800}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.LastAnnounce.Equal(testTime) {
+		t.Fatalf("expected %v, got %v", testTime, decoded.LastAnnounce)
+	}
+	// The RFC3339 field wins when the two disagree.
+	err = json.Unmarshal([]byte(`{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"`+testTime.Format(time.RFC3339)+`","last_announce_unix":1}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.LastAnnounce.Equal(testTime) {
+		t.Fatalf("expected %v, got %v", testTime, decoded.LastAnnounce)
+	}
+
+	// Pruning and the success check work off the decoded time.
+	cfg := testConfig(t)
+	setClock(t, testTime.Add(time.Minute))
+	old := testTime.Add(-2 * cfg.PruneAfter).Unix()
+	db := newFakeDB()
+	db.storeRaw(testTweak, []byte(`{"version":1,"servers":[`+
+		`{"name":"old.siasky.dev","ip":"2.2.2.2","last_announce_unix":`+strconv.FormatInt(old, 10)+`},`+
+		`{"name":"`+cfg.OwnName+`","ip":"1.1.1.1","last_announce_unix":`+strconv.FormatInt(testTime.Unix(), 10)+`}]}`))
+	list, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prune = true
+	if pruned := pruneServers(list, cfg); len(pruned) != 1 || pruned[0].Name != cfg.OwnName {
+		t.Fatalf("expected only our record to survive, got %v", pruned)
+	}
+	if err = checkSuccess(context.Background(), db, cfg, testTweak, list); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+var (
+	// ErrInvalidSignature is returned when a record isn't signed by the key
+	// it claims or by the key we trust for its name.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// recordHash returns the hash of the fields of a record which its signature
+// covers.
+func recordHash(s server) crypto.Hash {
+	return crypto.HashAll(s.Name, s.IP, s.LastAnnounce.UnixNano())
+}
+
+// signRecord returns a copy of the given record, signed with the given key.
+func signRecord(s server, sk crypto.SecretKey) server {
+	pk := sk.PublicKey()
+	sig := crypto.SignHash(recordHash(s), sk)
+	s.PublicKey = hex.EncodeToString(pk[:])
+	s.Signature = hex.EncodeToString(sig[:])
+	return s
+}
+
+// verifyRecord checks the signature of the given record. Unsigned records are
+// valid, unless the given trusted keys contain a key for their name. Anyone
+// with the entropy can write any record, so the signature only proves anything
+// when the key is pinned.
+func verifyRecord(s server, trusted map[string]crypto.PublicKey) error {
+	trustedKey, pinned := trusted[s.Name]
+	if s.PublicKey == "" && s.Signature == "" {
+		if pinned {
+			return errors.AddContext(ErrInvalidSignature, "record of "+s.Name+" is not signed")
+		}
+		return nil
+	}
+	pk, err := parsePublicKey(s.PublicKey)
+	if err != nil {
+		return errors.Extend(err, ErrInvalidSignature)
+	}
+	if pinned && pk != trustedKey {
+		return errors.AddContext(ErrInvalidSignature, "record of "+s.Name+" is signed by an untrusted key")
+	}
+	b, err := hex.DecodeString(s.Signature)
+	if err != nil || len(b) != crypto.SignatureSize {
+		return errors.AddContext(ErrInvalidSignature, "malformed signature")
+	}
+	var sig crypto.Signature
+	copy(sig[:], b)
+	if crypto.VerifyHash(recordHash(s), pk, sig) != nil {
+		return errors.AddContext(ErrInvalidSignature, "record of "+s.Name+" doesn't match its signature")
+	}
+	return nil
+}
+
+// dropUnverified returns the records of the given list whose signatures are
+// valid, see verifyRecord. The records which fail are logged and dropped.
+func dropUnverified(list []server, trusted map[string]crypto.PublicKey) []server {
+	verified := list[:0:0]
+	for _, s := range list {
+		err := verifyRecord(s, trusted)
+		if err != nil {
+			logger.Warn("dropping record with an invalid signature", "name", s.Name, "error", err)
+			continue
+		}
+		verified = append(verified, s)
+	}
+	return verified
+}
+
+// signOwnRecords signs our own record and the records of our aliases on the
+// given list in place. It's a no-op if we don't have a node key.
+func signOwnRecords(list []server, cfg config) []server {
+	if len(cfg.NodeKey) == 0 {
+		return list
+	}
+	var seed [crypto.EntropySize]byte
+	copy(seed[:], cfg.NodeKey)
+	sk, _ := crypto.GenerateKeyPairDeterministic(seed)
+	for i, s := range list {
+		if isServer(s, cfg.OwnName, cfg.NodeID) || isAlias(s, cfg.AliasNames) {
+			list[i] = signRecord(s, sk)
+		}
+	}
+	return list
+}
+
+// isAlias checks whether s is the record of one of the given aliases.
+func isAlias(s server, aliases []string) bool {
+	for _, alias := range aliases {
+		if s.ID == "" && s.Name == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePublicKey parses a hex encoded ed25519 public key.
+func parsePublicKey(s string) (crypto.PublicKey, error) {
+	var pk crypto.PublicKey
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return pk, errors.AddContext(err, "malformed public key")
+	}
+	if len(b) != len(pk) {
+		return pk, errors.New(fmt.Sprintf("malformed public key, expected %d bytes, got %d", len(pk), len(b)))
+	}
+	copy(pk[:], b)
+	return pk, nil
+}
+
+// parseTrustedKeys parses a comma-separated list of name=key pairs, where the
+// key is a hex encoded ed25519 public key.
+func parseTrustedKeys(value string) (map[string]crypto.PublicKey, error) {
+	keys := make(map[string]crypto.PublicKey)
+	for _, pair := range strings.Split(value, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return nil, errors.New(fmt.Sprintf("invalid trusted key '%s', expected name=key", pair))
+		}
+		pk, err := parsePublicKey(key)
+		if err != nil {
+			return nil, errors.AddContext(err, "invalid trusted key for "+name)
+		}
+		keys[name] = pk
+	}
+	return keys, nil
+}
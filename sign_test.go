@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestSignedRecords verifies that our signed records verify, that records
+// of pinned servers must be signed with the pinned key and that reading the
+// list drops the ones which aren't.
+func TestSignedRecords(t *testing.T) {
+	var seed, forgerSeed [crypto.EntropySize]byte
+	seed[0], forgerSeed[0] = 1, 2
+	sk, pk := crypto.GenerateKeyPairDeterministic(seed)
+	forgerKey, _ := crypto.GenerateKeyPairDeterministic(forgerSeed)
+	trusted := map[string]crypto.PublicKey{"a.siasky.dev": pk}
+
+	genuine := signRecord(server{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}, sk)
+	forged := signRecord(server{Name: "a.siasky.dev", IP: "6.6.6.6", LastAnnounce: testTime}, forgerKey)
+	unsigned := server{Name: "a.siasky.dev", IP: "6.6.6.6", LastAnnounce: testTime}
+	other := server{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime}
+
+	if err := verifyRecord(genuine, trusted); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []server{forged, unsigned} {
+		if err := verifyRecord(s, trusted); !errors.Contains(err, ErrInvalidSignature) {
+			t.Fatalf("expected ErrInvalidSignature, got %v", err)
+		}
+	}
+	// Without pinned keys, only invalid signatures count.
+	if err := verifyRecord(unsigned, nil); err != nil {
+		t.Fatal(err)
+	}
+	tampered := genuine
+	tampered.IP = "6.6.6.6"
+	if err := verifyRecord(tampered, nil); !errors.Contains(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	cfg := testConfig(t)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{forged, other, genuine})
+	list, _, err := getServerList(context.Background(), db, testTweak, cfg.OwnName, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := ownRecordIndex(list, "a.siasky.dev", ""); len(list) != 2 || i < 0 || list[i].IP != "1.1.1.1" {
+		t.Fatalf("expected the forged record to be dropped, got %v", list)
+	}
+	if len(validateList([]server{forged}, trusted)) != 1 {
+		t.Fatal("expected validation to reject the forged record")
+	}
+}
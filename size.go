@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// defaultMaxBytes is the default maximum size of the stored list. SkyDB
+	// uploads the list as a skyfile, which needs to fit into a single 4 MiB
+	// sector together with its metadata.
+	defaultMaxBytes = 4<<20 - 64<<10
+
+	// oversizeFail selects failing when the list exceeds MaxBytes.
+	oversizeFail = "fail"
+	// oversizeShed selects dropping the servers with the oldest announces
+	// until the list fits into MaxBytes.
+	oversizeShed = "shed"
+)
+
+var (
+	// ErrListTooLarge is returned when the stored list would exceed the
+	// maximum size. Retrying won't make it smaller, so we don't.
+	ErrListTooLarge = errors.New("list too large")
+)
+
+// encodeList returns the bytes we store for the given list, encoded as
+// configured by cfg.Pretty and cfg.Compress.
+func encodeList(list []server, cfg config) ([]byte, error) {
+	data, err := marshalServerList(list, cfg.Pretty)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to marshal server list")
+	}
+	if cfg.Compress {
+		return compressList(data)
+	}
+	return data, nil
+}
+
+// checkSize ensures that the given encoded list doesn't exceed cfg.MaxBytes.
+func checkSize(data []byte, cfg config) error {
+	if len(data) > cfg.MaxBytes {
+		return errors.AddContext(ErrListTooLarge, fmt.Sprintf("the list takes %d bytes, the maximum is %d", len(data), cfg.MaxBytes))
+	}
+	return nil
+}
+
+// fitList makes sure that the given list fits into cfg.MaxBytes once encoded.
+// With the shed strategy it drops the servers with the oldest announces, apart
+// from our own records, see ownRecords, until the list fits, otherwise it fails. Dropping servers only
+// ever shrinks the list, so we binary search the number of servers to drop
+// rather than encoding the list once per dropped server.
+func fitList(list []server, cfg config) ([]server, error) {
+	data, err := encodeList(list, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if checkSize(data, cfg) == nil || cfg.OversizeStrategy != oversizeShed {
+		return list, checkSize(data, cfg)
+	}
+	// Order the other servers from the newest announce to the oldest, like
+	// MaxCountPolicy does, so dropping d servers drops the last d of them.
+	own := make([]bool, len(list))
+	byAge := make([]int, 0, len(list))
+	for i, s := range list {
+		if isServer(s, cfg.OwnName, cfg.NodeID) || isAlias(s, cfg.AliasNames) {
+			own[i] = true
+			continue
+		}
+		byAge = append(byAge, i)
+	}
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return list[byAge[i]].LastAnnounce.After(list[byAge[j]].LastAnnounce)
+	})
+	// dropOldest returns the list without the d servers with the oldest
+	// announces, preserving the order of the remaining servers.
+	dropOldest := func(d int) []server {
+		keep := append([]bool(nil), own...)
+		for _, i := range byAge[:len(byAge)-d] {
+			keep[i] = true
+		}
+		var kept []server
+		for i, s := range list {
+			if keep[i] {
+				kept = append(kept, s)
+			}
+		}
+		return kept
+	}
+	var encodeErr error
+	drop := sort.Search(len(byAge)+1, func(d int) bool {
+		data, err := encodeList(dropOldest(d), cfg)
+		if err != nil {
+			encodeErr = err
+			return true
+		}
+		return checkSize(data, cfg) == nil
+	})
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	if drop > len(byAge) {
+		data, _ = encodeList(dropOldest(len(byAge)), cfg)
+		return nil, errors.AddContext(checkSize(data, cfg), "the list doesn't fit even after shedding all other servers")
+	}
+	fitted := dropOldest(drop)
+	_, _, shed := diffLists(list, fitted)
+	for _, s := range shed {
+		logger.Warn("shedding server to fit the list size limit", "name", s.Name, "last_announce", s.LastAnnounce, "max_bytes", cfg.MaxBytes)
+	}
+	return fitted, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestFitList verifies that an oversized list fails with the fail strategy and
+// that the shed strategy drops as few of the oldest servers as it takes to fit,
+// never our own records.
+func TestFitList(t *testing.T) {
+	cfg := testConfig(t)
+	// Our own record is the oldest, so it would be the first to go.
+	list := []server{{Name: cfg.OwnName, IP: "1.1.1.1", LastAnnounce: testTime.Add(-time.Hour)}}
+	for i := 0; i < 20; i++ {
+		list = append(list, server{Name: fmt.Sprintf("s%02d.siasky.dev", i), IP: "2.2.2.2", LastAnnounce: testTime.Add(time.Duration(i) * time.Minute)})
+	}
+	sizeOf := func(list []server) int {
+		data, err := encodeList(list, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(data)
+	}
+	// Leave room for our record and the 5 newest servers.
+	cfg.MaxBytes = sizeOf(append([]server{list[0]}, list[16:]...))
+
+	cfg.OversizeStrategy = oversizeFail
+	if _, err := fitList(list, cfg); !errors.Contains(err, ErrListTooLarge) {
+		t.Fatalf("expected ErrListTooLarge, got %v", err)
+	}
+
+	cfg.OversizeStrategy = oversizeShed
+	fitted, err := fitList(list, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) != 6 || fitted[0].Name != cfg.OwnName || fitted[1].Name != "s15.siasky.dev" {
+		t.Fatalf("expected our record and the 5 newest servers, got %v", fitted)
+	}
+	if sizeOf(fitted) > cfg.MaxBytes {
+		t.Fatal("the fitted list is too large")
+	}
+
+	// A list which fits stays as it is.
+	if fitted, err = fitList(list[:3], cfg); err != nil || len(fitted) != 3 {
+		t.Fatalf("expected the list to stay as it is, got %v and %v", fitted, err)
+	}
+
+	// A list which doesn't fit even with only our record fails.
+	cfg.MaxBytes = sizeOf(list[:1]) - 1
+	if _, err = fitList(list, cfg); !errors.Contains(err, ErrListTooLarge) {
+		t.Fatalf("expected ErrListTooLarge, got %v", err)
+	}
+
+	// The records of our aliases are ours as well.
+	cfg.AliasNames = []string{"alias.siasky.dev"}
+	withAlias := append([]server{list[0], {Name: "alias.siasky.dev", IP: "1.1.1.1", LastAnnounce: list[0].LastAnnounce}}, list[1:]...)
+	cfg.MaxBytes = sizeOf(append(withAlias[:2:2], list[19:]...))
+	if fitted, err = fitList(withAlias, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) >= len(withAlias) || fitted[0].Name != cfg.OwnName || fitted[1].Name != "alias.siasky.dev" {
+		t.Fatalf("expected our records to survive the shedding, got %v", fitted)
+	}
+}
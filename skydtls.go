@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// loadCAPool reads the PEM encoded certificates in the file at path into a
+// new certificate pool.
+func loadCAPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read ca file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("failed to parse ca file, it doesn't contain any PEM encoded certificates")
+	}
+	return pool, nil
+}
+
+// skydTLSTransport returns a copy of the given transport which talks TLS to
+// skydAddress, verifying its certificate against rootCAs, or against the
+// system's roots if rootCAs is nil. Connections to all other addresses are
+// left alone.
+//
+// The skyd client always builds plain http:// URLs and uses the default
+// transport, so upgrading the connections to skyd at dial time is the only way
+// to talk TLS to it. Since http:// requests never go through the transport's
+// own TLS handshake, we don't end up doing TLS twice.
+func skydTLSTransport(base *http.Transport, skydAddress string, rootCAs *x509.CertPool) (*http.Transport, error) {
+	host, _, err := net.SplitHostPort(skydAddress)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid skyd address")
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:    rootCAs,
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t := base.Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil || addr != skydAddress {
+			return conn, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, errors.AddContext(err, "failed to establish a tls connection to skyd")
+		}
+		return tlsConn, nil
+	}
+	return t, nil
+}
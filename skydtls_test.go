@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSkydTLSTransport verifies that the transport talks TLS to skyd when we
+// trust its CA and refuses to when we don't.
+func TestSkydTLSTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ready")
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "https://")
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := loadCAPool(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &http.Transport{}
+	transport, err := skydTLSTransport(base, addr, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The skyd client builds plain http:// URLs.
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/daemon/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ready" {
+		t.Fatalf("unexpected response %q", body)
+	}
+
+	// The system's roots don't know the test CA.
+	transport, err = skydTLSTransport(base, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&http.Client{Transport: transport}).Get("http://" + addr + "/daemon/ready"); err == nil {
+		t.Fatal("expected an untrusted certificate to be rejected")
+	}
+
+	// Invalid CA files and a CA without TLS are config errors.
+	badPath := filepath.Join(t.TempDir(), "bad.pem")
+	if err = os.WriteFile(badPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadCAPool(badPath); err == nil {
+		t.Fatal("expected an invalid ca file to be rejected")
+	}
+	setTestEnv(t)
+	t.Setenv("SERVERLIST_SKYD_CA", caPath)
+	if _, err = getConfig(); err == nil {
+		t.Fatal("expected a ca without tls to be rejected")
+	}
+	t.Setenv("SERVERLIST_SKYD_TLS", "true")
+	cfg, err := getConfig()
+	if err != nil || !cfg.SkydTLS || cfg.SkydRootCAs == nil {
+		t.Fatalf("expected tls with the ca, got %v", err)
+	}
+}
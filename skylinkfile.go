@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// writeSkylinkFile atomically replaces the file at path with the given
+// skylinks, one per line. We write to a temporary file in the same directory
+// and rename it, so readers never see a partially written file.
+func writeSkylinkFile(path string, skylinks []string) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errors.AddContext(err, "failed to create temporary skylink file")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	_, err = f.WriteString(strings.Join(skylinks, "\n") + "\n")
+	if err != nil {
+		f.Close()
+		return errors.AddContext(err, "failed to write temporary skylink file")
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		return errors.AddContext(err, "failed to set skylink file permissions")
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.AddContext(err, "failed to close temporary skylink file")
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return errors.AddContext(err, "failed to replace skylink file")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestSkylinkFile verifies that a successful run writes exactly the skylink to
+// the skylink file and that a failed one leaves an existing file untouched.
+func TestSkylinkFile(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SkylinkFile = filepath.Join(t.TempDir(), "skylink")
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	opts := options{output: outputText, noSpread: true}
+
+	if code := run(context.Background(), cfg, newFakeDB(), newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code != exitSuccess {
+		t.Fatalf("expected exit code %d, got %d", exitSuccess, code)
+	}
+	b, err := os.ReadFile(cfg.SkylinkFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "AQADrvzKkzixb4ZPzMETzSnyzB-o8UdC_fbydh73-93S8g\n" {
+		t.Fatalf("unexpected skylink file %q", b)
+	}
+
+	err = os.WriteFile(cfg.SkylinkFile, []byte("previous\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDB()
+	db.onRead = func(int) error { return errors.New("[" + skydAuthError + "]") }
+	if code := run(context.Background(), cfg, db, newFakeSkyd(), pk, staticIP("1.1.1.1"), opts); code == exitSuccess {
+		t.Fatal("expected the run to fail")
+	}
+	b, err = os.ReadFile(cfg.SkylinkFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "previous\n" {
+		t.Fatalf("expected the skylink file to be untouched, got %q", b)
+	}
+	entries, err := os.ReadDir(filepath.Dir(cfg.SkylinkFile))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected no temporary files, got %v and %v", entries, err)
+	}
+}
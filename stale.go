@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// staleMargin is how long before a server gets pruned we report it as
+	// stale by default.
+	staleMargin = 24 * time.Hour
+)
+
+// defaultStaleAfter returns the default age after which we report a server as
+// stale, which is staleMargin before it gets pruned. When the servers get
+// pruned within staleMargin, we report them halfway to getting pruned instead.
+func defaultStaleAfter(pruneAfter time.Duration) time.Duration {
+	if pruneAfter > staleMargin {
+		return pruneAfter - staleMargin
+	}
+	return pruneAfter / 2
+}
+
+// isStale checks whether the given server hasn't announced within staleAfter of
+// the given time. It's the single definition of staleness used by -stale,
+// -list and the status page.
+func isStale(s server, now time.Time, staleAfter time.Duration) bool {
+	return !s.LastAnnounce.After(now.Add(-staleAfter))
+}
+
+// staleEntries returns the servers on the list which haven't announced within
+// staleAfter of the given time, oldest first, see isStale.
+func staleEntries(list []server, now time.Time, staleAfter time.Duration) []server {
+	var stale []server
+	for _, s := range list {
+		if isStale(s, now, staleAfter) {
+			stale = append(stale, s)
+		}
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastAnnounce.Before(stale[j].LastAnnounce)
+	})
+	return stale
+}
+
+// printStale prints the stale servers of the list with the given skylink,
+// together with their age, see staleEntries.
+func printStale(skylink string, list []server, now time.Time, staleAfter time.Duration) error {
+	stale := staleEntries(list, now, staleAfter)
+	fmt.Printf("%s: %d of %d servers haven't announced for %s\n", skylink, len(stale), len(list), staleAfter)
+	if len(stale) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tIP\tLAST ANNOUNCE\tAGE")
+	for _, s := range stale {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, s.IP, s.LastAnnounce.Format(time.RFC3339), now.Sub(s.LastAnnounce).Round(time.Minute))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStaleEntries verifies that staleEntries returns the servers which
+// haven't announced within staleAfter, oldest first, and that -list and the
+// status page agree with it.
+func TestStaleEntries(t *testing.T) {
+	staleAfter := 48 * time.Hour
+	list := []server{
+		{Name: "fresh.siasky.dev", LastAnnounce: testTime.Add(-time.Hour)},
+		{Name: "old.siasky.dev", LastAnnounce: testTime.Add(-72 * time.Hour)},
+		{Name: "edge.siasky.dev", LastAnnounce: testTime.Add(-staleAfter)},
+		{Name: "older.siasky.dev", LastAnnounce: testTime.Add(-96 * time.Hour)},
+	}
+	stale := staleEntries(list, testTime, staleAfter)
+	if len(stale) != 3 || stale[0].Name != "older.siasky.dev" || stale[1].Name != "old.siasky.dev" || stale[2].Name != "edge.siasky.dev" {
+		t.Fatalf("unexpected stale servers %v", stale)
+	}
+
+	setClock(t, testTime)
+	st := newStatus(staleAfter)
+	st.recordObservation("skylink", list)
+	annotated := st.response().List
+	for i, s := range annotateStaleness(list, testTime, staleAfter) {
+		if s.Stale != isStale(list[i], testTime, staleAfter) || annotated[i].Stale != s.Stale {
+			t.Fatalf("%s: the stale definitions disagree", s.Name)
+		}
+		if s.Stale != (s.Staleness >= 0.5) {
+			t.Fatalf("%s: stale is %t at staleness %f", s.Name, s.Stale, s.Staleness)
+		}
+	}
+}
+
+// TestDefaultStaleAfter verifies that servers count as stale a day before
+// they get pruned, or halfway to it when they get pruned within a day.
+func TestDefaultStaleAfter(t *testing.T) {
+	if d := defaultStaleAfter(7 * 24 * time.Hour); d != 6*24*time.Hour {
+		t.Fatalf("expected 144h, got %v", d)
+	}
+	if d := defaultStaleAfter(12 * time.Hour); d != 6*time.Hour {
+		t.Fatalf("expected 6h, got %v", d)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+type (
+	// annotatedServer is a server together with how stale its record is. It's
+	// a derived view which we never store.
+	annotatedServer struct {
+		server
+		Stale     bool
+		Staleness float64
+	}
+
+	// listResult is the machine-readable view of a list.
+	listResult struct {
+		Skylink string            `json:"skylink"`
+		Servers []annotatedServer `json:"servers"`
+	}
+)
+
+// annotateStaleness computes the staleness of each server on the list at the
+// given time. The staleness grows from 0 for a server which just announced
+// towards 1, halving the remaining freshness every staleAfter. A server counts
+// as stale once it hasn't announced for a full staleAfter, see isStale, i.e.
+// once its staleness reaches 0.5.
+func annotateStaleness(list []server, now time.Time, staleAfter time.Duration) []annotatedServer {
+	annotated := make([]annotatedServer, 0, len(list))
+	for _, s := range list {
+		age := now.Sub(s.LastAnnounce)
+		if age < 0 {
+			age = 0
+		}
+		staleness := 1 - math.Pow(0.5, float64(age)/float64(staleAfter))
+		annotated = append(annotated, annotatedServer{
+			server:    s,
+			Stale:     isStale(s, now, staleAfter),
+			Staleness: staleness,
+		})
+	}
+	return annotated
+}
+
+// MarshalJSON encodes the server just like server.MarshalJSON does, adding the
+// stale and staleness fields.
+func (s annotatedServer) MarshalJSON() ([]byte, error) {
+	b, err := s.server.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["stale"], err = json.Marshal(s.Stale)
+	if err != nil {
+		return nil, err
+	}
+	fields["staleness"], err = json.Marshal(s.Staleness)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
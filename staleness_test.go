@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAnnotateStaleness verifies the staleness of servers of several ages and
+// that the annotation shows up in the JSON view but never in the stored list.
+func TestAnnotateStaleness(t *testing.T) {
+	staleAfter := 24 * time.Hour
+	tests := []struct {
+		age       time.Duration
+		stale     bool
+		staleness float64
+	}{
+		{-time.Hour, false, 0},
+		{0, false, 0},
+		{12 * time.Hour, false, 1 - math.Sqrt(0.5)},
+		{staleAfter - time.Second, false, 0.5},
+		{staleAfter, true, 0.5},
+		{2 * staleAfter, true, 0.75},
+		{10 * staleAfter, true, 1 - math.Pow(0.5, 10)},
+	}
+	var list []server
+	for _, tt := range tests {
+		list = append(list, server{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime.Add(-tt.age)})
+	}
+	annotated := annotateStaleness(list, testTime, staleAfter)
+	if len(annotated) != len(tests) {
+		t.Fatalf("expected %d servers, got %d", len(tests), len(annotated))
+	}
+	for i, tt := range tests {
+		if annotated[i].Stale != tt.stale || math.Abs(annotated[i].Staleness-tt.staleness) > 1e-4 {
+			t.Fatalf("age %v: expected stale %t and staleness %v, got %t and %v", tt.age, tt.stale, tt.staleness, annotated[i].Stale, annotated[i].Staleness)
+		}
+	}
+
+	b, err := json.Marshal(annotated[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"stale":true`) || !strings.Contains(string(b), `"staleness":0.75`) {
+		t.Fatalf("expected the staleness fields, got %s", b)
+	}
+	b, err = marshalServerList(list, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "stale") {
+		t.Fatalf("expected the stored list to be unchanged, got %s", b)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// status keeps track of the outcome of the latest announce. It's safe for
+	// concurrent use and it can serve its values as JSON.
+	status struct {
+		lastAnnounce time.Time
+		skylink      string
+		servers      int
+		own          *server
+		list         []server
+		staleAfter   time.Duration
+		lastError    string
+		mu           sync.Mutex
+	}
+
+	// statusResponse is the JSON representation of the status.
+	statusResponse struct {
+		LastAnnounce *time.Time        `json:"last_announce"`
+		Skylink      string            `json:"skylink,omitempty"`
+		Servers      int               `json:"servers"`
+		Own          *server           `json:"own"`
+		List         []annotatedServer `json:"list"`
+		LastError    string            `json:"last_error,omitempty"`
+	}
+)
+
+// newStatus returns a new, empty status instance. The list is annotated with
+// the staleness of each server, which counts as stale after the given
+// duration, see annotateStaleness.
+func newStatus(staleAfter time.Duration) *status {
+	return &status{staleAfter: staleAfter}
+}
+
+// recordAnnounce registers a successful announce to the list with the given
+// skylink. It also clears the last error.
+func (s *status) recordAnnounce(skylink string, list []server, ownName, nodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAnnounce = time.Now()
+	s.skylink = skylink
+	s.servers = len(list)
+	s.list = append([]server(nil), list...)
+	s.own = nil
+	for i := range list {
+		if isServer(list[i], ownName, nodeID) {
+			own := list[i]
+			s.own = &own
+			break
+		}
+	}
+	s.lastError = ""
+}
+
+// recordObservation registers a successful read of the list with the given
+// skylink in observe mode, in which we're not on the list ourselves. It also
+// clears the last error.
+func (s *status) recordObservation(skylink string, list []server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skylink = skylink
+	s.servers = len(list)
+	s.list = append([]server(nil), list...)
+	s.own = nil
+	s.lastError = ""
+}
+
+// recordError registers a failed announce.
+func (s *status) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+}
+
+// response returns a snapshot of the status.
+func (s *status) response() statusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := statusResponse{
+		Skylink:   s.skylink,
+		Servers:   s.servers,
+		Own:       s.own,
+		List:      annotateStaleness(s.list, clock(), s.staleAfter),
+		LastError: s.lastError,
+	}
+	// We don't report an announce time before we've had an announce.
+	if !s.lastAnnounce.IsZero() {
+		t := s.lastAnnounce
+		resp.LastAnnounce = &t
+	}
+	return resp
+}
+
+// statusHandler serves the status as JSON to requests which carry the given
+// bearer token.
+func statusHandler(s *status, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		b, err := json.Marshal(s.response())
+		if err != nil {
+			http.Error(w, "failed to marshal status", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	})
+}
+
+// authorized checks whether the request carries the given bearer token. An
+// empty token never authorizes a request.
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if token == "" || len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) == 1
+}
+
+// serveStatus starts an HTTP server which exposes the given status on /status
+// to requests which carry the given bearer token. The server shuts down when
+// the context is done.
+func serveStatus(ctx context.Context, addr, token string, s *status) error {
+	mux := http.NewServeMux()
+	mux.Handle("/status", statusHandler(s, token))
+	return serve(ctx, addr, mux)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestStatusHandler verifies that the status is only served to requests with
+// the right bearer token and that it reflects the latest announce.
+func TestStatusHandler(t *testing.T) {
+	setClock(t, testTime)
+	st := newStatus(defaultStaleAfter(defaultPruneAfter))
+	own := server{Name: "dev1.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}
+	st.recordAnnounce("skylink", []server{own, {Name: "other.siasky.dev", LastAnnounce: testTime}}, own.Name, "")
+	h := statusHandler(st, "secret")
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic secret", "Bearer "} {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected 401, got %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		LastAnnounce *string `json:"last_announce"`
+		Skylink      string  `json:"skylink"`
+		Servers      int     `json:"servers"`
+		Own          *server `json:"own"`
+		LastError    string  `json:"last_error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.LastAnnounce == nil || resp.Skylink != "skylink" || resp.Servers != 2 || resp.Own == nil || resp.Own.IP != "1.1.1.1" {
+		t.Fatalf("unexpected status %s", rec.Body.Bytes())
+	}
+
+	st.recordError(errors.New("write failed"))
+	if resp := st.response(); resp.LastError != "write failed" || resp.Servers != 2 {
+		t.Fatalf("unexpected status after an error %+v", resp)
+	}
+
+	// An empty token never authorizes a request.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	statusHandler(st, "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+// TestStatusAuthFailure verifies that the status reports skyd rejecting our
+// password, even though we give up on the remaining lists.
+func TestStatusAuthFailure(t *testing.T) {
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	db.onRead = func(int) error { return errors.New("[" + skydAuthError + "]") }
+	st := newStatus(cfg.StaleAfter)
+	_, err := announceAll(context.Background(), db, newFakeSkyd(), cfg, pk, staticIP("1.1.1.1"), newMetrics(), nil, st, nil, outputText, false)
+	if !errors.Contains(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	if resp := st.response(); resp.LastError == "" {
+		t.Fatalf("expected the auth failure in the status, got %+v", resp)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// timeSourceLocal selects the local clock as our time source.
+	timeSourceLocal = "local"
+	// timeSourceSkyd selects skyd as our time source.
+	timeSourceSkyd = "skyd"
+
+	// ntpPort is the default port of NTP servers.
+	ntpPort = "123"
+	// ntpEpochOffset is the number of seconds between the NTP epoch, 1900,
+	// and the Unix epoch, 1970.
+	ntpEpochOffset = 2208988800
+
+	// timeSourceTimeout is the maximum duration of a time source query.
+	timeSourceTimeout = 5 * time.Second
+)
+
+// timeSource returns the current time according to some external authority.
+type timeSource func(ctx context.Context) (time.Time, error)
+
+// newTimeSource returns the time source selected by the config, or nil when we
+// use the local clock.
+func newTimeSource(cfg config) timeSource {
+	switch cfg.TimeSource {
+	case "", timeSourceLocal:
+		return nil
+	case timeSourceSkyd:
+		return func(ctx context.Context) (time.Time, error) {
+			return skydTime(ctx, http.DefaultClient, cfg.SkydAddress, cfg.SkydUserAgent)
+		}
+	default:
+		return func(ctx context.Context) (time.Time, error) {
+			return ntpTime(ctx, cfg.TimeSource)
+		}
+	}
+}
+
+// parseNTPServer validates the address of an NTP server, adding the default
+// port if it's missing.
+func parseNTPServer(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ntpPort
+	}
+	if net.ParseIP(host) == nil && !hostnameRegex.MatchString(host) {
+		return "", errors.New(fmt.Sprintf("invalid ntp server '%s'", addr))
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// syncClock makes the clock follow the given time source, see clockOffset.
+// When the source fails, the clock stays as it is.
+func syncClock(ctx context.Context, src timeSource) {
+	if src == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeSourceTimeout)
+	defer cancel()
+	before := time.Now()
+	now, err := src(ctx)
+	if err != nil {
+		logger.Warn("failed to query the time source, keeping the previous clock", "error", err)
+		return
+	}
+	// We assume that the source read its time halfway through the query.
+	after := time.Now()
+	offset := now.Sub(before.Add(after.Sub(before) / 2))
+	logger.Debug("synced the clock", "offset", offset)
+	clockOffset.Store(int64(offset))
+}
+
+// skydTime returns skyd's current time, which we take from the Date header of
+// its response. skyd sends the header even when the request isn't
+// authenticated, so we don't need the API password.
+func skydTime(ctx context.Context, c *http.Client, skydAddress, userAgent string) (time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+skydAddress+"/daemon/ready", nil)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to create skyd request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.Do(req)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to query skyd")
+	}
+	defer resp.Body.Close()
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New("skyd didn't send a Date header")
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "invalid Date header from skyd")
+	}
+	return t, nil
+}
+
+// ntpTime queries the NTP server at the given address for the current time
+// using SNTP, as described in RFC 4330.
+func ntpTime(ctx context.Context, addr string) (time.Time, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to connect to ntp server")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return time.Time{}, errors.AddContext(err, "failed to set ntp deadline")
+		}
+	}
+	req := make([]byte, 48)
+	// leap indicator 0, version 3, mode 3 (client)
+	req[0] = 0x1b
+	_, err = conn.Write(req)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to send ntp request")
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to read ntp response")
+	}
+	if n < len(resp) {
+		return time.Time{}, errors.New(fmt.Sprintf("short ntp response of %d bytes", n))
+	}
+	// The response must come from a server, i.e. mode 4, and a stratum of 0
+	// signals a kiss-of-death packet.
+	if resp[0]&0x7 != 4 || resp[1] == 0 {
+		return time.Time{}, errors.New("invalid ntp response")
+	}
+	// We use the transmit timestamp, a 32.32 fixed point number of seconds
+	// since the NTP epoch.
+	secs := binary.BigEndian.Uint32(resp[40:44])
+	frac := binary.BigEndian.Uint32(resp[44:48])
+	nsec := (int64(frac) * int64(time.Second)) >> 32
+	return time.Unix(int64(secs)-ntpEpochOffset, nsec).UTC(), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestSyncClock verifies that syncing the clock applies the offset of the time
+// source and that a failing time source leaves the clock as it is.
+func TestSyncClock(t *testing.T) {
+	t.Cleanup(func() { clockOffset.Store(0) })
+	ahead := func(context.Context) (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}
+	syncClock(context.Background(), ahead)
+	if d := clock().Sub(time.Now()); d < 59*time.Minute || d > 61*time.Minute {
+		t.Fatalf("expected the clock to be an hour ahead, it's %v", d)
+	}
+	failing := func(context.Context) (time.Time, error) {
+		return time.Time{}, errors.New("unreachable")
+	}
+	syncClock(context.Background(), failing)
+	if d := clock().Sub(time.Now()); d < 59*time.Minute {
+		t.Fatalf("expected the clock to stay an hour ahead, it's %v", d)
+	}
+}
+
+// TestSyncClockConcurrently verifies that syncing the clock doesn't race with
+// reading it. It's meant to be run with -race.
+func TestSyncClockConcurrently(t *testing.T) {
+	t.Cleanup(func() { clockOffset.Store(0) })
+	src := func(context.Context) (time.Time, error) {
+		return time.Now().Add(time.Minute), nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			clock()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		syncClock(context.Background(), src)
+	}
+	<-done
+}
+
+// TestParseNTPServer verifies that we add the default port to NTP servers and
+// reject invalid ones.
+func TestParseNTPServer(t *testing.T) {
+	tests := []struct {
+		addr  string
+		want  string
+		valid bool
+	}{
+		{"pool.ntp.org", "pool.ntp.org:123", true},
+		{"pool.ntp.org:1123", "pool.ntp.org:1123", true},
+		{"1.2.3.4", "1.2.3.4:123", true},
+		{"not a host", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseNTPServer(tt.addr)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Fatalf("%s: expected %q and valid %t, got %q and %v", tt.addr, tt.want, tt.valid, got, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// validateListFile reads the list in the given file, which can be in any of
+// the formats we store lists in, and validates it, see validateList. It only
+// returns an error when the file can't be read or parsed at all. Signatures are
+// verified against the given trusted keys.
+func validateListFile(path string, trusted map[string]crypto.PublicKey) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read list file")
+	}
+	list, err := unmarshalServerList(b)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse list file")
+	}
+	return validateList(list, trusted), nil
+}
+
+// validateList checks every server on the list with validateServer and makes
+// sure that no name is on the list more than once. Names are compared in their
+// canonical form, see normalizedName. It returns a description of each problem
+// it finds.
+func validateList(list []server, trusted map[string]crypto.PublicKey) []string {
+	var problems []string
+	seen := make(map[string]int, len(list))
+	for i, s := range list {
+		err := validateServer(s, trusted)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("server %d (%s): %v", i, s.Name, err))
+		}
+		name := normalizedName(s.Name)
+		if j, exists := seen[name]; exists {
+			problems = append(problems, fmt.Sprintf("server %d (%s): duplicate of server %d", i, s.Name, j))
+			continue
+		}
+		seen[name] = i
+	}
+	return problems
+}
+
+// normalizedName returns the canonical, lowercase form of the given server
+// name, see parseOwnName. Names which don't parse are only lowercased, since
+// validateServer reports them anyway.
+func normalizedName(name string) string {
+	if parsed, _, err := parseOwnName(name); err == nil {
+		name = parsed
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidateList verifies that validateList accepts a clean list and reports
+// each category of defect.
+func TestValidateList(t *testing.T) {
+	setClock(t, testTime)
+	valid := server{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime}
+	other := server{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime}
+	with := func(f func(*server)) server {
+		s := valid
+		f(&s)
+		return s
+	}
+	tests := []struct {
+		name    string
+		list    []server
+		problem string
+	}{
+		{"clean", []server{valid, other}, ""},
+		{"invalid name", []server{with(func(s *server) { s.Name = "not a name" })}, "invalid name"},
+		{"missing name", []server{with(func(s *server) { s.Name = "" })}, "missing name"},
+		{"invalid ip", []server{with(func(s *server) { s.IP = "1.1.1" })}, "invalid ip"},
+		{"missing announce", []server{with(func(s *server) { s.LastAnnounce = time.Time{} })}, "missing last_announce"},
+		{"future announce", []server{with(func(s *server) { s.LastAnnounce = testTime.Add(24 * time.Hour) })}, "in the future"},
+		{"duplicate", []server{valid, other, valid}, "duplicate of server 0"},
+		{"duplicate with another id", []server{valid, with(func(s *server) { s.ID = "0b2f6c4e-7a1d-4b8e-9c3f-5d6e7f8a9b0c" })}, "duplicate of server 0"},
+		{"duplicate in another case", []server{valid, with(func(s *server) { s.Name = "A.Siasky.dev" })}, "duplicate of server 0"},
+		{"duplicate with a scheme", []server{valid, with(func(s *server) { s.Name = "https://a.siasky.dev:443" })}, "duplicate of server 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateList(tt.list, nil)
+			if tt.problem == "" {
+				if len(problems) != 0 {
+					t.Fatalf("expected no problems, got %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Fatalf("expected a problem containing %q, got %v", tt.problem, problems)
+			}
+		})
+	}
+}
+
+// TestValidateListFile verifies that validateListFile reads lists in the
+// formats we store them in and fails on files it can't parse.
+func TestValidateListFile(t *testing.T) {
+	setClock(t, testTime)
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	record := `{"name":"a.siasky.dev","ip":"1.1.1.1","last_announce":"2022-06-01T12:00:00Z"}`
+	for _, data := range []string{"[" + record + "]", `{"version":1,"servers":[` + record + `]}`} {
+		problems, err := validateListFile(write("list.json", data), nil)
+		if err != nil || len(problems) != 0 {
+			t.Fatalf("expected a clean list, got %v and %v", problems, err)
+		}
+	}
+	if _, err := validateListFile(write("garbage.json", "not json"), nil); err == nil {
+		t.Fatal("expected an unparseable file to fail")
+	}
+	if _, err := validateListFile(filepath.Join(dir, "missing.json"), nil); err == nil {
+		t.Fatal("expected a missing file to fail")
+	}
+}
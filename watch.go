@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// watch reads each list every interval and prints how it changed since the
+// previous read to w, until the context is done. It never writes to the lists.
+func watch(ctx context.Context, db skyDB, cfg config, pk crypto.PublicKey, interval time.Duration, w io.Writer) {
+	type seen struct {
+		list []server
+		rev  uint64
+	}
+	last := make(map[[32]byte]seen)
+	for {
+		for _, tweak := range cfg.Tweaks {
+			sl := skymodules.NewSkylinkV2(types.Ed25519PublicKey(pk), tweak)
+			readCtx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			list, rev, err := getServerList(readCtx, db, tweak, cfg.OwnName, cfg.TrustedKeys)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Error("failed to read server list", "skylink", sl.String(), "error", err)
+				continue
+			}
+			prev, ok := last[tweak]
+			last[tweak] = seen{list: list, rev: rev}
+			if !ok {
+				fmt.Fprintf(w, "%s %s: revision %d, %d servers\n", clock().Format(time.RFC3339), sl.String(), rev, len(list))
+				continue
+			}
+			if rev == prev.rev {
+				continue
+			}
+			fmt.Fprintf(w, "%s %s: revision %d -> %d, %d servers\n", clock().Format(time.RFC3339), sl.String(), prev.rev, rev, len(list))
+			printWatchDiff(w, prev.list, list)
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// printWatchDiff prints the differences between the two versions of a list,
+// one server per line, marked with + for added, - for removed and ~ for
+// updated servers.
+func printWatchDiff(w io.Writer, old, new []server) {
+	added, updated, removed := diffLists(old, new)
+	oldByKey := make(map[serverKey]server, len(old))
+	for _, s := range old {
+		oldByKey[s.key()] = s
+	}
+	for _, s := range added {
+		fmt.Fprintf(w, "  + %s %s\n", s.Name, s.IP)
+	}
+	for _, s := range removed {
+		fmt.Fprintf(w, "  - %s %s\n", s.Name, s.IP)
+	}
+	for _, s := range updated {
+		o := oldByKey[s.key()]
+		switch {
+		case o.IP != s.IP:
+			fmt.Fprintf(w, "  ~ %s ip %s -> %s\n", s.Name, o.IP, s.IP)
+		case o.Name != s.Name:
+			fmt.Fprintf(w, "  ~ %s renamed from %s\n", s.Name, o.Name)
+		default:
+			fmt.Fprintf(w, "  ~ %s announced at %s\n", s.Name, s.LastAnnounce.Format(time.RFC3339))
+		}
+	}
+}
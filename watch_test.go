@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+)
+
+// TestWatch verifies that watch prints the list once and then only its
+// changes, and that it never writes.
+func TestWatch(t *testing.T) {
+	setClock(t, testTime)
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	db.storeList(t, testTweak, []server{
+		{Name: "a.siasky.dev", IP: "1.1.1.1", LastAnnounce: testTime},
+		{Name: "b.siasky.dev", IP: "2.2.2.2", LastAnnounce: testTime},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.onRead = func(n int) error {
+		switch n {
+		case 2:
+			db.storeList(t, testTweak, []server{
+				{Name: "a.siasky.dev", IP: "4.4.4.4", LastAnnounce: testTime},
+				{Name: "c.siasky.dev", IP: "3.3.3.3", LastAnnounce: testTime},
+			})
+		case 4:
+			cancel()
+		}
+		return nil
+	}
+	var out bytes.Buffer
+	watch(ctx, db, cfg, pk, time.Millisecond, &out)
+
+	sl := "AQADrvzKkzixb4ZPzMETzSnyzB-o8UdC_fbydh73-93S8g"
+	want := "2022-06-01T12:00:00Z " + sl + ": revision 1, 2 servers\n" +
+		"2022-06-01T12:00:00Z " + sl + ": revision 1 -> 2, 2 servers\n" +
+		"  + c.siasky.dev 3.3.3.3\n" +
+		"  - b.siasky.dev 2.2.2.2\n" +
+		"  ~ a.siasky.dev ip 1.1.1.1 -> 4.4.4.4\n"
+	if out.String() != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, out.String())
+	}
+	if db.writeCount() != 0 {
+		t.Fatalf("expected no writes, got %d", db.writeCount())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// webhookSuccess is the status we report for a successful announce.
+	webhookSuccess = "success"
+	// webhookFailure is the status we report for a failed announce.
+	webhookFailure = "failure"
+)
+
+type (
+	// webhook notifies an HTTP endpoint about the outcome of each announce.
+	webhook struct {
+		url    string
+		client *http.Client
+	}
+
+	// webhookPayload is the JSON body we POST to the webhook.
+	webhookPayload struct {
+		Status    string    `json:"status"`
+		Name      string    `json:"name"`
+		Skylink   string    `json:"skylink"`
+		Error     string    `json:"error,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+)
+
+// newWebhook returns a webhook which POSTs to the given URL using the given
+// client. It returns nil if the URL is empty, which disables notifications.
+func newWebhook(url string, c *http.Client) *webhook {
+	if url == "" {
+		return nil
+	}
+	return &webhook{
+		url:    url,
+		client: c,
+	}
+}
+
+// notify POSTs the outcome of an announce to the given list to the webhook.
+// A nil err means that the announce succeeded. Calling it on a nil webhook is a
+// no-op.
+func (w *webhook) notify(ctx context.Context, name, skylink string, err error) error {
+	if w == nil {
+		return nil
+	}
+	p := webhookPayload{
+		Status:    webhookSuccess,
+		Name:      name,
+		Skylink:   skylink,
+		Timestamp: clock().UTC(),
+	}
+	if err != nil {
+		p.Status = webhookFailure
+		p.Error = err.Error()
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.AddContext(err, "failed to marshal webhook payload")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.AddContext(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to call webhook")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("webhook responded with status code %d", resp.StatusCode))
+	}
+	return nil
+}
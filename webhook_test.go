@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestWebhook verifies that we POST the outcome of each announce to the
+// webhook and that a webhook which fails doesn't fail the announce.
+func TestWebhook(t *testing.T) {
+	setClock(t, testTime)
+	var payloads []webhookPayload
+	var mu sync.Mutex
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	wh := newWebhook(srv.URL, srv.Client())
+
+	err := wh.notify(context.Background(), "a.siasky.dev", "skylink", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wh.notify(context.Background(), "a.siasky.dev", "skylink", errors.New("no luck"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []webhookPayload{
+		{Status: webhookSuccess, Name: "a.siasky.dev", Skylink: "skylink", Timestamp: testTime},
+		{Status: webhookFailure, Name: "a.siasky.dev", Skylink: "skylink", Error: "no luck", Timestamp: testTime},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("expected %d payloads, got %v", len(want), payloads)
+	}
+	for i := range want {
+		if payloads[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want[i], payloads[i])
+		}
+	}
+
+	// An unconfigured webhook is a no-op.
+	if newWebhook("", nil).notify(context.Background(), "a.siasky.dev", "skylink", nil) != nil {
+		t.Fatal("expected a nil webhook to do nothing")
+	}
+
+	// A webhook which fails doesn't fail the announce.
+	status = http.StatusInternalServerError
+	if wh.notify(context.Background(), "a.siasky.dev", "skylink", nil) == nil {
+		t.Fatal("expected an error status to fail")
+	}
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	failures, err := announceAll(context.Background(), db, newFakeSkyd(), cfg, pk, staticIP("1.1.1.1"), newMetrics(), nil, newStatus(cfg.StaleAfter), wh, outputText, false)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("expected the announce to succeed, got %v and %v", failures, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last := payloads[len(payloads)-1]; last.Status != webhookSuccess || last.Name != cfg.OwnName {
+		t.Fatalf("unexpected payload %v", last)
+	}
+}
+
+// TestWebhookAuthFailure verifies that we report skyd rejecting our password to
+// the webhook, even though we give up on the remaining lists.
+func TestWebhookAuthFailure(t *testing.T) {
+	var payloads []webhookPayload
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	wh := newWebhook(srv.URL, srv.Client())
+
+	cfg := testConfig(t)
+	_, pk := crypto.GenerateKeyPairDeterministic(cfg.Entropy)
+	db := newFakeDB()
+	db.onRead = func(int) error { return errors.New("[" + skydAuthError + "]") }
+	_, err := announceAll(context.Background(), db, newFakeSkyd(), cfg, pk, staticIP("1.1.1.1"), newMetrics(), nil, newStatus(cfg.StaleAfter), wh, outputText, false)
+	if !errors.Contains(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 1 || payloads[0].Status != webhookFailure || payloads[0].Error == "" {
+		t.Fatalf("expected the auth failure to be reported, got %v", payloads)
+	}
+}